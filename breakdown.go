@@ -0,0 +1,62 @@
+package marisa
+
+import "github.com/pgaskin/go-marisa/internal/wexcept"
+
+// LevelSize is the in-memory size in bytes of each component of a single trie
+// level. See [Trie.SizeBreakdown].
+type LevelSize struct {
+	Louds         uint32 // tree structure bit vector
+	TerminalFlags uint32 // bit vector of nodes which end a key
+	LinkFlags     uint32 // bit vector of nodes which link to the tail or next level
+	Bases         uint32 // labels, or the lower 8 bits of links
+	Extras        uint32 // the upper bits of links
+	Tail          uint32 // suffixes (only in the last level)
+	Cache         uint32 // transition cache
+}
+
+// Total returns the total size of the level.
+func (s LevelSize) Total() uint32 {
+	return s.Louds + s.TerminalFlags + s.LinkFlags + s.Bases + s.Extras + s.Tail + s.Cache
+}
+
+// SizeBreakdown returns the in-memory size of each component of each nested
+// trie level, starting with the first one. The totals of all levels add up to
+// [Trie.TotalSize].
+func (t *Trie) SizeBreakdown() ([]LevelSize, error) {
+	if t.mod == nil {
+		return nil, errorKind(ErrNotInitialized, nil)
+	}
+	if err := t.err(); err != nil {
+		return nil, err
+	}
+	s := make([]LevelSize, t.numTries)
+	for i := range s {
+		var err error
+		if s[i], err = t.levelSize(uint32(i)); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// levelSize returns the size of a level of the dictionary.
+func (t *Trie) levelSize(level uint32) (s LevelSize, err error) {
+	if err := func() (err error) {
+		defer wexcept.Catch(&err)
+		defer t.mod.marisa.SetStackPointer(t.mod.marisa.StackPointer())
+		louds, terminalFlags, linkFlags, bases, extras, tail, cache := t.mod.marisa.XTrieLevel(int32(t.h.ptr), int32(level))
+		s = LevelSize{
+			Louds:         uint32(louds),
+			TerminalFlags: uint32(terminalFlags),
+			LinkFlags:     uint32(linkFlags),
+			Bases:         uint32(bases),
+			Extras:        uint32(extras),
+			Tail:          uint32(tail),
+			Cache:         uint32(cache),
+		}
+		return
+	}(); err != nil {
+		return s, t.mod.fault(err)
+	}
+	return s, nil
+}
@@ -0,0 +1,44 @@
+package marisa_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pgaskin/go-marisa"
+)
+
+func TestSizeBreakdown(t *testing.T) {
+	var trie marisa.Trie
+	if _, err := trie.SizeBreakdown(); !errors.Is(err, marisa.ErrNotInitialized) {
+		t.Errorf("expected not initialized error, got %v", err)
+	}
+
+	trie = *mustWordsTrie()
+	defer trie.Close()
+
+	levels, err := trie.SizeBreakdown()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if len(levels) != int(trie.NumTries()) {
+		t.Errorf("expected %d levels, got %d", trie.NumTries(), len(levels))
+	}
+	var total uint32
+	for i, s := range levels {
+		if s.Louds == 0 || s.Bases == 0 || s.Cache == 0 {
+			t.Errorf("level %d: expected non-zero structure sizes, got %+v", i, s)
+		}
+		if last := i == len(levels)-1; last != (s.Tail != 0) {
+			t.Errorf("level %d: expected only the last level to have a tail, got %+v", i, s)
+		}
+		total += s.Total()
+	}
+	if exp := trie.TotalSize(); total != exp {
+		t.Errorf("expected total %d, got %d", exp, total)
+	}
+
+	trie.Close()
+	if _, err := trie.SizeBreakdown(); !errors.Is(err, marisa.ErrClosed) {
+		t.Errorf("expected closed error, got %v", err)
+	}
+}
@@ -1,11 +1,11 @@
 package marisa
 
 import (
+	"cmp"
+	"context"
 	"errors"
+	"io"
 	"iter"
-
-	"github.com/pgaskin/go-marisa/internal/wexcept"
-	"github.com/pgaskin/go-marisa/internal/wmem"
 )
 
 // Config specifies options for a dictionary. Any unspecified options will be
@@ -17,6 +17,35 @@ type Config struct {
 	NodeOrder  NodeOrder
 }
 
+// WeightMerge specifies how the weights of keys specified multiple times are
+// combined while building a dictionary (see [Trie.BuildWeightsMerge]).
+type WeightMerge int
+
+const (
+	MergeSum   WeightMerge = iota + 1 // add the weights (the default)
+	MergeMax                          // use the largest weight
+	MergeMin                          // use the smallest weight
+	MergeFirst                        // use the first weight
+	MergeLast                         // use the last weight
+)
+
+func (m WeightMerge) String() string {
+	switch m {
+	case MergeSum:
+		return "sum"
+	case MergeMax:
+		return "max"
+	case MergeMin:
+		return "min"
+	case MergeFirst:
+		return "first"
+	case MergeLast:
+		return "last"
+	default:
+		return "unknown"
+	}
+}
+
 func configFlags(c Config) (flags configFlag, ok bool) {
 	if f, ok := numTriesFlag(c.NumTries); ok {
 		flags |= f
@@ -55,61 +84,176 @@ func (t *Trie) Build(keys iter.Seq[string], cfg Config) error {
 
 // BuildWeights builds a dictionary out of the specified set of keys and
 // weights. If a key is specified multiple times, the weights are accumulated.
+// The keys are copied into the module as they are read, so they are not all
+// held on the Go heap. The built dictionary uses the same amount of memory as
+// if it were loaded with [Trie.ReadFrom].
 func (t *Trie) BuildWeights(keys iter.Seq2[string, float32], cfg Config) error {
-	flag, ok := configFlags(cfg)
-	if !ok {
-		return errors.New("invalid config")
+	return t.buildWeights(keys, cfg, 0, nil)
+}
+
+// BuildWeightsMerge is like [Trie.BuildWeights], but combines the weights of
+// keys specified multiple times using merge (if zero, [MergeSum]), and returns
+// those keys in the order they were first repeated.
+//
+// To find the duplicates before building, every distinct key is first copied
+// into a map and slice on the Go heap, so this needs enough memory to hold all
+// of the keys in addition to the module.
+func (t *Trie) BuildWeightsMerge(keys iter.Seq2[string, float32], cfg Config, merge WeightMerge) ([]string, error) {
+	if merge < 0 || merge > MergeLast {
+		return nil, errorKind(ErrInvalidConfig, errors.New("invalid weight merge policy"))
+	}
+	var duplicates []string
+	if err := t.buildWeights(keys, cfg, merge, &duplicates); err != nil {
+		return nil, err
 	}
+	return duplicates, nil
+}
 
-	sa := wmem.SliceMemory(0, maxAlloc)
-	mod, err := instantiate(sa)
-	if err != nil {
+func (t *Trie) buildWeights(keys iter.Seq2[string, float32], cfg Config, merge WeightMerge, duplicates *[]string) error {
+	var built Trie
+	if err := build(&built, keys, cfg, merge, duplicates); err != nil {
+		return err
+	}
+	if err := t.loadBuilt(&built, maxAlloc); err != nil {
 		return err
 	}
+	t.cacheLevel = cmp.Or(cfg.CacheLevel, NormalCache)
+	return nil
+}
+
+// loadBuilt loads the dictionary built into src into t, then closes src. On
+// error, t is left unchanged.
+//
+// The build module still holds the keyset (which the built trie may also
+// reference) and whatever it allocated while building, so the trie is loaded
+// into a new right-sized module. To avoid also holding a serialized copy of it
+// on the Go heap, it is streamed directly from one module memory to the other.
+// The new module memory is limited to max bytes.
+func (t *Trie) loadBuilt(src *Trie, max uint64) error {
+	defer src.Close()
+
+	capacity := uint64(src.ioSize) + scratchSpace
+	if capacity >= max {
+		capacity = 0 // otherwise, it would be allocated up-front at the full size
+	}
+	mem := growableMemory(capacity, max)
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := src.WriteTo(pw)
+		pw.CloseWithError(err)
+	}()
+	_, err := t.readFromMemory(pr, mem)
+	pr.Close() // stop the writer if the read failed
+	<-done
+	return err
+}
+
+// BuildTo is like [Trie.BuildWeights], but writes the dictionary to w instead
+// of loading it, then immediately releases the memory used to build it. It
+// returns the stats of the written dictionary.
+func BuildTo(w io.Writer, keys iter.Seq2[string, float32], cfg Config) (Stats, error) {
+	var built Trie
+	if err := build(&built, keys, cfg, 0, nil); err != nil {
+		return Stats{}, err
+	}
+	defer built.Close()
+
+	if _, err := built.WriteTo(w); err != nil {
+		return Stats{}, err
+	}
+	return built.Stats(), nil
+}
+
+// BuildFile is like [BuildTo], but atomically writes the dictionary to the
+// named file. The dictionary is written to a temporary file in the same
+// directory, which is synced to disk, then renamed over the target, so the file
+// is never left truncated if the build fails or is interrupted. If the file
+// already exists, its permissions are preserved. Otherwise, it is created with
+// mode 0644.
+func BuildFile(name string, keys iter.Seq2[string, float32], cfg Config) (stats Stats, err error) {
+	err = writeFileAtomic(name, 0644, func(w io.Writer) (err error) {
+		stats, err = BuildTo(w, keys, cfg)
+		return
+	})
+	if err != nil {
+		return Stats{}, err
+	}
+	return stats, nil
+}
 
-	const step = 1024
-	alloc := step
-	ptr, err := mod.Alloc(step)
+// build builds a dictionary into t, leaving it in the module used to build it.
+// If duplicates is not nil, the weights are merged using merge first, and it is
+// set to the keys which were specified multiple times.
+func build(t *Trie, keys iter.Seq2[string, float32], cfg Config, merge WeightMerge, duplicates *[]string) error {
+	if _, ok := configFlags(cfg); !ok {
+		return errorKind(ErrInvalidConfig, nil)
+	}
+	if duplicates != nil {
+		// MARISA always accumulates weights, so we need to merge them first
+		var dups []string
+		keys, dups = mergeWeights(keys, merge)
+		if duplicates != nil {
+			*duplicates = dups
+		}
+	}
+	b, err := NewBuilder(BuilderOptions{})
 	if err != nil {
 		return err
 	}
-	defer func() {
-		if ptr != 0 {
-			mod.Free(ptr)
-		}
-	}()
+	defer b.Close()
+
 	for key, weight := range keys {
-		n := len(key)
-		if n > alloc {
-			old := ptr
-			ptr = 0
-			mod.Free(old)
-			alloc = (n + step - 1) / step * step
-			ptr, err = mod.Alloc(alloc)
-			if err != nil {
-				return err
-			}
+		if err := b.Add(key, weight); err != nil {
+			return err
 		}
-		buf, ok := wmem.Bytes(mod.mem, ptr, uint32(len(key)))
+	}
+	return b.build(context.Background(), t, cfg)
+}
+
+// mergeWeights combines the weights of duplicate keys using merge. It returns
+// the keys in the order they were first seen, and the keys which were specified
+// multiple times in the order they were first repeated.
+func mergeWeights(keys iter.Seq2[string, float32], merge WeightMerge) (iter.Seq2[string, float32], []string) {
+	var (
+		index      = map[string]int{}
+		merged     []string
+		weights    []float32
+		duplicated []bool
+		duplicates []string
+	)
+	for key, weight := range keys {
+		i, ok := index[key]
 		if !ok {
-			panic("bad allocation")
+			index[key] = len(merged)
+			merged = append(merged, key)
+			weights = append(weights, weight)
+			duplicated = append(duplicated, false)
+			continue
 		}
-		copy(buf, key)
-
-		if err := func() (err error) {
-			defer wexcept.Catch(&err)
-			mod.marisa.XBuildPush(int32(ptr), int32(uint32(n)), weight)
-			return
-		}(); err != nil {
-			return err
+		if !duplicated[i] {
+			duplicated[i] = true
+			duplicates = append(duplicates, key)
+		}
+		switch cmp.Or(merge, MergeSum) {
+		case MergeSum:
+			weights[i] += weight
+		case MergeMax:
+			weights[i] = max(weights[i], weight)
+		case MergeMin:
+			weights[i] = min(weights[i], weight)
+		case MergeFirst:
+		case MergeLast:
+			weights[i] = weight
 		}
 	}
-	if err := func() (err error) {
-		defer wexcept.Catch(&err)
-		mod.marisa.XBuild(int32(uint32(flag)))
-		return
-	}(); err != nil {
-		return err
-	}
-	return t.swap(mod)
+	return func(yield func(string, float32) bool) {
+		for i, key := range merged {
+			if !yield(key, weights[i]) {
+				return
+			}
+		}
+	}, duplicates
 }
@@ -1,15 +1,25 @@
 package marisa_test
 
 import (
+	"bytes"
 	"crypto/rand"
+	"errors"
+	"fmt"
 	"io"
 	"iter"
 	"maps"
 	"math/bits"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
 	"slices"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/pgaskin/go-marisa"
+	"github.com/pgaskin/go-marisa/internal"
 	"github.com/pgaskin/go-marisa/testdata"
 )
 
@@ -83,6 +93,41 @@ func TestBuild(t *testing.T) {
 			t.Errorf("incorrect keys:\nact: %#v\nexp: %#v", act, exp)
 		}
 	})
+	t.Run("WeightMerge", func(t *testing.T) {
+		keys := []weightKey{
+			{"a", 1},
+			{"c", 3.2},
+			{"b", 3},
+			{"a", 4},
+			{"d", 3.5},
+			{"c", 2},
+		}
+		for merge, exp := range map[marisa.WeightMerge][]string{
+			0:                 {"c", "a", "d", "b"},
+			marisa.MergeSum:   {"c", "a", "d", "b"},
+			marisa.MergeMax:   {"a", "d", "c", "b"},
+			marisa.MergeMin:   {"d", "b", "c", "a"},
+			marisa.MergeFirst: {"d", "c", "b", "a"},
+			marisa.MergeLast:  {"a", "d", "b", "c"},
+		} {
+			var trie marisa.Trie
+			dups, err := trie.BuildWeightsMerge(weightKeys(keys), marisa.Config{}, merge)
+			if err != nil {
+				t.Fatalf("%s: error: %v", merge, err)
+			}
+			if act := mustTrieKeys(&trie); !slices.Equal(act, exp) {
+				t.Errorf("%s: incorrect keys:\nact: %#v\nexp: %#v", merge, act, exp)
+			}
+			if exp := []string{"a", "c"}; !slices.Equal(dups, exp) {
+				t.Errorf("%s: incorrect duplicates:\nact: %#v\nexp: %#v", merge, dups, exp)
+			}
+		}
+
+		var trie marisa.Trie
+		if _, err := trie.BuildWeightsMerge(weightKeys(keys), marisa.Config{}, marisa.MergeLast+1); !errors.Is(err, marisa.ErrInvalidConfig) {
+			t.Errorf("expected invalid config error, got %v", err)
+		}
+	})
 	t.Run("Config", func(t *testing.T) {
 		cfg := marisa.Config{
 			NumTries:   1,                 // default is 3
@@ -242,6 +287,73 @@ func TestBuild(t *testing.T) {
 	})
 }
 
+func TestBuildTo(t *testing.T) {
+	expected := mustWordsTrieData()
+	stats := mustWordsTrie().Stats()
+
+	words := func(yield func(string, float32) bool) {
+		for _, word := range testdata.Words {
+			if !yield(word, 1) {
+				return
+			}
+		}
+	}
+
+	t.Run("Writer", func(t *testing.T) {
+		var buf bytes.Buffer
+		if act, err := marisa.BuildTo(&buf, words, marisa.Config{}); err != nil {
+			t.Fatalf("error: %v", err)
+		} else if act != stats {
+			t.Errorf("expected stats %+v, got %+v", stats, act)
+		}
+		if !bytes.Equal(buf.Bytes(), expected) {
+			t.Errorf("incorrect output")
+		}
+
+		errWrite := errors.New("write failed")
+		if _, err := marisa.BuildTo(errorWriter{errWrite}, words, marisa.Config{}); !errors.Is(err, errWrite) {
+			t.Errorf("expected write error, got %v", err)
+		}
+	})
+	t.Run("File", func(t *testing.T) {
+		dir := t.TempDir()
+		name := filepath.Join(dir, "words.dat")
+		if act, err := marisa.BuildFile(name, words, marisa.Config{}); err != nil {
+			t.Fatalf("error: %v", err)
+		} else if act != stats {
+			t.Errorf("expected stats %+v, got %+v", stats, act)
+		}
+		if buf, err := os.ReadFile(name); err != nil {
+			t.Fatalf("error: %v", err)
+		} else if !bytes.Equal(buf, expected) {
+			t.Errorf("incorrect output")
+		}
+
+		if _, err := marisa.BuildFile(name, words, marisa.Config{NumTries: -1}); !errors.Is(err, marisa.ErrInvalidConfig) {
+			t.Errorf("expected invalid config error, got %v", err)
+		}
+		if buf, err := os.ReadFile(name); err != nil {
+			t.Fatalf("error: %v", err)
+		} else if !bytes.Equal(buf, expected) {
+			t.Errorf("existing file should be left unchanged on error")
+		}
+		if ents, err := os.ReadDir(dir); err != nil {
+			t.Fatalf("error: %v", err)
+		} else if len(ents) != 1 {
+			t.Errorf("temporary file should be removed on error, got %d files", len(ents))
+		}
+	})
+}
+
+// errorWriter always returns Err.
+type errorWriter struct {
+	Err error
+}
+
+func (w errorWriter) Write(p []byte) (int, error) {
+	return 0, w.Err
+}
+
 type weightKey struct {
 	Key    string
 	Weight float32
@@ -278,3 +390,112 @@ func mustTrieKeys(t *marisa.Trie) []string {
 	}
 	return ss
 }
+
+// BenchmarkBuildPeakRSS reports the peak RSS increase while building and
+// loading a dictionary with each memory backend. It requires linux.
+func BenchmarkBuildPeakRSS(b *testing.B) {
+	if err := resetPeakRSS(); err != nil {
+		b.Skipf("cannot measure peak rss: %v", err)
+	}
+
+	keys := slices.Repeat(testdata.Words, 4)
+	for i := range keys[len(testdata.Words):] {
+		keys[len(testdata.Words)+i] += strconv.Itoa(i)
+	}
+	var ref marisa.Trie
+	if err := ref.Build(slices.Values(keys), marisa.Config{}); err != nil {
+		panic(err)
+	}
+	buf, err := ref.MarshalBinary()
+	if err != nil {
+		panic(err)
+	}
+	ref.Close()
+
+	for _, backend := range []struct {
+		Name    string
+		Virtual bool
+	}{
+		{"Slice", false},
+		{"Virtual", true},
+	} {
+		for _, op := range []struct {
+			Name string
+			Fn   func(*marisa.Trie) error
+		}{
+			{"Build", func(trie *marisa.Trie) error {
+				return trie.Build(slices.Values(keys), marisa.Config{})
+			}},
+			{"ReadFrom", func(trie *marisa.Trie) error {
+				_, err := trie.ReadFrom(bytes.NewReader(buf))
+				return err
+			}},
+		} {
+			b.Run(backend.Name+"/"+op.Name, func(b *testing.B) {
+				defer func(v bool) { internal.NoVirtualMemory = v }(internal.NoVirtualMemory)
+				internal.NoVirtualMemory = !backend.Virtual
+
+				var peak uint64
+				for b.Loop() {
+					runtime.GC()
+					debug.FreeOSMemory()
+					base, err := currentRSS()
+					if err != nil {
+						b.Fatalf("error: %v", err)
+					}
+					if err := resetPeakRSS(); err != nil {
+						b.Fatalf("error: %v", err)
+					}
+
+					var trie marisa.Trie
+					if err := op.Fn(&trie); err != nil {
+						b.Fatalf("error: %v", err)
+					}
+
+					hwm, err := peakRSS()
+					if err != nil {
+						b.Fatalf("error: %v", err)
+					}
+					peak = max(peak, hwm-min(hwm, base))
+					trie.Close()
+				}
+				b.ReportMetric(float64(peak)/1024/1024, "peak-rss-MiB")
+			})
+		}
+	}
+}
+
+// resetPeakRSS resets the peak RSS of the current process.
+func resetPeakRSS() error {
+	return os.WriteFile("/proc/self/clear_refs", []byte("5"), 0)
+}
+
+// currentRSS gets the RSS of the current process.
+func currentRSS() (uint64, error) {
+	return procStatus("VmRSS")
+}
+
+// peakRSS gets the peak RSS of the current process since the last call to
+// resetPeakRSS.
+func peakRSS() (uint64, error) {
+	return procStatus("VmHWM")
+}
+
+// procStatus gets a size from /proc/self/status in bytes.
+func procStatus(key string) (uint64, error) {
+	buf, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		return 0, err
+	}
+	for line := range strings.Lines(string(buf)) {
+		if k, v, ok := strings.Cut(line, ":"); ok && k == key {
+			v = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(v), "kB"))
+			n, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return n * 1024, nil
+		}
+	}
+	return 0, fmt.Errorf("%s not found", key)
+}
@@ -0,0 +1,327 @@
+package marisa
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+
+	"github.com/pgaskin/go-marisa/internal/wexcept"
+	"github.com/pgaskin/go-marisa/internal/wmem"
+)
+
+// BuilderOptions configures a [Builder].
+type BuilderOptions struct {
+	// MemoryLimit is the maximum amount of module memory to use while adding
+	// keys, building the dictionary, and loading it. Since the build module is
+	// still alive while the dictionary is loaded, both count against it. If
+	// exceeded, the build fails with an error matching [ErrOutOfMemory]. If
+	// zero, it is only limited by the maximum dictionary size.
+	MemoryLimit uint64
+
+	// Progress, if not nil, is called periodically while adding keys and
+	// building the dictionary. While building, it is best-effort: it is only
+	// called when the module memory grows, so there may be long gaps between
+	// calls (e.g., while the last trie level is built in memory which was
+	// already allocated), and none at all once the memory stops growing.
+	Progress func(BuildProgress)
+}
+
+// BuildPhase is a stage of building a dictionary.
+type BuildPhase int
+
+const (
+	BuildPhaseAdd   BuildPhase = iota + 1 // adding keys
+	BuildPhaseBuild                       // building the trie
+	BuildPhaseLoad                        // loading the built trie into a right-sized module
+)
+
+func (p BuildPhase) String() string {
+	switch p {
+	case BuildPhaseAdd:
+		return "add"
+	case BuildPhaseBuild:
+		return "build"
+	case BuildPhaseLoad:
+		return "load"
+	}
+	return "unknown"
+}
+
+// BuildProgress describes the progress of a [Builder].
+type BuildProgress struct {
+	Phase  BuildPhase
+	Keys   int    // number of keys added
+	Memory uint64 // module memory usage
+}
+
+// buildProgressInterval is the number of keys to add between progress
+// reports.
+const buildProgressInterval = 1 << 16
+
+// Builder incrementally builds a dictionary. Keys are copied into the module as
+// they are added, so the caller doesn't need to keep them in memory.
+//
+// MARISA builds the trie in a single call, so while building, progress is
+// reported, and cancellation and the memory limit are checked, whenever the
+// module memory grows. If the build is stopped, the partially-built dictionary
+// is discarded.
+//
+// A Builder must not be used concurrently.
+type Builder struct {
+	noCopy noCopy
+	opt    BuilderOptions
+	mod    *module
+	err    error // if set, the builder is unusable
+
+	ptr   uint32 // key buffer
+	alloc int    // key buffer size
+	keys  int
+
+	phase BuildPhase
+	ctx   context.Context // set while building
+	stop  error           // set if memory growth was refused
+}
+
+// NewBuilder creates a new [Builder].
+func NewBuilder(opt BuilderOptions) (*Builder, error) {
+	b := &Builder{
+		opt:   opt,
+		phase: BuildPhaseAdd,
+	}
+	limit := uint64(maxAlloc)
+	if opt.MemoryLimit != 0 {
+		limit = min(limit, opt.MemoryLimit)
+	}
+	mod, err := instantiate(&builderMemory{Memory: growableMemory(0, limit), b: b})
+	if err != nil {
+		if b.stop != nil {
+			return nil, b.stop
+		}
+		return nil, err
+	}
+	b.mod = mod
+	return b, nil
+}
+
+// builderMemory checks the memory limit and cancellation, and reports progress
+// when the module memory grows.
+type builderMemory struct {
+	wmem.Memory
+	b *Builder
+}
+
+func (m *builderMemory) Grow(delta, max int64) int64 {
+	b := m.b
+	if delta != 0 {
+		if b.ctx != nil {
+			if err := b.ctx.Err(); err != nil {
+				b.stop = err
+				return -1
+			}
+		}
+		if limit := b.opt.MemoryLimit; limit != 0 {
+			if size := uint64(len(*m.Memory.Slice())) + uint64(delta)<<wmem.PageBits; size > limit {
+				b.stop = errorKind(ErrOutOfMemory, fmt.Errorf("memory limit of %d bytes exceeded", limit))
+				return -1
+			}
+		}
+	}
+	old := m.Memory.Grow(delta, max)
+	if delta != 0 && old >= 0 && b.phase == BuildPhaseBuild {
+		b.progress(m.Memory)
+	}
+	return old
+}
+
+// Add adds a key with the specified weight. If a key is added multiple times,
+// the weights are accumulated.
+func (b *Builder) Add(key string, weight float32) error {
+	return builderPush(b, key, weight)
+}
+
+// AddBytes is like [Builder.Add], but takes the key as a byte slice.
+func (b *Builder) AddBytes(key []byte, weight float32) error {
+	return builderPush(b, key, weight)
+}
+
+func builderPush[T string | []byte](b *Builder, key T, weight float32) (err error) {
+	if b.err != nil {
+		return b.err
+	}
+	defer func() {
+		if err != nil {
+			err = b.fail(err)
+		}
+	}()
+
+	const step = 1024
+	if n := len(key); n > b.alloc || b.ptr == 0 {
+		if b.ptr != 0 {
+			b.mod.Free(b.ptr)
+			b.ptr = 0
+		}
+		alloc := max(step, (n+step-1)/step*step)
+		ptr, err := b.mod.Alloc(alloc)
+		if err != nil {
+			return err
+		}
+		b.ptr, b.alloc = ptr, alloc
+	}
+	buf, ok := wmem.Bytes(b.mod.mem, b.ptr, uint32(len(key)))
+	if !ok {
+		return internalError("bad allocation")
+	}
+	copy(buf, key)
+
+	if err := func() (err error) {
+		defer wexcept.Catch(&err)
+		defer b.mod.marisa.SetStackPointer(b.mod.marisa.StackPointer())
+		b.mod.marisa.XBuildPush(int32(b.ptr), int32(uint32(len(key))), weight)
+		return
+	}(); err != nil {
+		return err
+	}
+
+	b.keys++
+	if b.keys%buildProgressInterval == 0 {
+		b.progress(b.mod.mem)
+	}
+	return nil
+}
+
+// Len returns the number of keys added, including duplicates.
+func (b *Builder) Len() int {
+	return b.keys
+}
+
+// MemoryUsage returns the amount of module memory currently used by the
+// builder. After the builder is finished or closed, it returns zero.
+func (b *Builder) MemoryUsage() uint64 {
+	if b.err != nil {
+		return 0
+	}
+	return uint64(len(*b.mod.mem.Slice()))
+}
+
+// Finish builds the dictionary out of the added keys. If ctx is cancelled, the
+// build is stopped, and the context error is returned. Cancellation while the
+// trie is being built is best-effort since it is only checked when the module
+// memory grows, so Finish may not return until the build step completes; it is
+// always checked again before the dictionary is loaded. Afterwards, the builder
+// is closed, and can no longer be used. If cfg is invalid, an error matching
+// [ErrInvalidConfig] is returned and the builder is left unchanged.
+func (b *Builder) Finish(ctx context.Context, cfg Config) (*Trie, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if _, ok := configFlags(cfg); !ok {
+		return nil, errorKind(ErrInvalidConfig, nil)
+	}
+
+	var built Trie
+	if err := b.build(ctx, &built, cfg); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		built.Close()
+		return nil, err
+	}
+
+	b.phase = BuildPhaseLoad
+	b.progress(built.mod.mem)
+	limit := uint64(maxAlloc)
+	if b.opt.MemoryLimit != 0 {
+		// the destination module gets whatever is left of the limit, and
+		// must at least be able to hold the dictionary
+		used := uint64(len(*built.mod.mem.Slice()))
+		if used+uint64(built.ioSize) > b.opt.MemoryLimit {
+			built.Close()
+			return nil, errorKind(ErrOutOfMemory, fmt.Errorf("memory limit of %d bytes exceeded", b.opt.MemoryLimit))
+		}
+		limit = min(limit, b.opt.MemoryLimit-used)
+	}
+	var t Trie
+	if err := t.loadBuilt(&built, limit); err != nil {
+		return nil, err
+	}
+	t.cacheLevel = cmp.Or(cfg.CacheLevel, NormalCache)
+	return &t, nil
+}
+
+// build builds the dictionary into t, leaving it in the module used to build
+// it. Afterwards, the builder is closed.
+func (b *Builder) build(ctx context.Context, t *Trie, cfg Config) error {
+	flag, ok := configFlags(cfg)
+	if !ok {
+		return errorKind(ErrInvalidConfig, nil)
+	}
+	if err := ctx.Err(); err != nil {
+		return b.fail(err)
+	}
+
+	b.phase = BuildPhaseBuild
+	b.progress(b.mod.mem)
+
+	b.ctx = ctx
+	defer func() { b.ctx = nil }()
+
+	if err := b.xbuild(b.mod, t, flag); err != nil {
+		return b.fail(err)
+	}
+	b.mod = nil
+	b.err = errorKind(ErrClosed, nil)
+	return nil
+}
+
+// xbuild builds the dictionary in mod, which must contain the keys added to
+// the builder (either the builder's own module, or a clone of it), then swaps t
+// to use mod. The key buffer is freed in mod, so it is only released from the
+// builder if mod is its own module.
+func (b *Builder) xbuild(mod *module, t *Trie, flag configFlag) error {
+	if b.ptr != 0 {
+		mod.Free(b.ptr)
+		if mod == b.mod {
+			b.ptr = 0
+		}
+	}
+	h, err := mod.newTrie(func(p int32) {
+		mod.marisa.XTrieBuild(p, int32(uint32(flag)))
+	})
+	if err != nil {
+		return err
+	}
+	return t.swap(mod, h)
+}
+
+// progress reports the current progress with the usage of mem.
+func (b *Builder) progress(mem wmem.Memory) {
+	if b.opt.Progress != nil {
+		b.opt.Progress(BuildProgress{
+			Phase:  b.phase,
+			Keys:   b.keys,
+			Memory: uint64(len(*mem.Slice())),
+		})
+	}
+}
+
+// fail releases the module, making the builder unusable. If the module memory
+// growth was refused, the reason is returned instead of err.
+func (b *Builder) fail(err error) error {
+	if b.stop != nil {
+		err = b.stop
+	}
+	b.err = err
+	b.mod.Close()
+	return err
+}
+
+// Close releases the memory used by the builder. If it was already finished,
+// failed, or closed, it returns an error matching [ErrClosed].
+func (b *Builder) Close() error {
+	if b.err != nil {
+		return errorKind(ErrClosed, nil)
+	}
+	b.err = errorKind(ErrClosed, nil)
+	b.mod.Close()
+	return nil
+}
@@ -0,0 +1,164 @@
+package marisa_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/pgaskin/go-marisa"
+	"github.com/pgaskin/go-marisa/testdata"
+)
+
+func TestBuilder(t *testing.T) {
+	expected := mustWordsTrieData()
+
+	// newBuilder returns a builder with all words added.
+	newBuilder := func(t *testing.T, opt marisa.BuilderOptions) *marisa.Builder {
+		t.Helper()
+		b, err := marisa.NewBuilder(opt)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		for i, word := range testdata.Words {
+			if i%2 == 0 {
+				err = b.Add(word, 1)
+			} else {
+				err = b.AddBytes([]byte(word), 1)
+			}
+			if err != nil {
+				t.Fatalf("add %q: %v", word, err)
+			}
+		}
+		return b
+	}
+
+	t.Run("Finish", func(t *testing.T) {
+		var phases []marisa.BuildPhase
+		b := newBuilder(t, marisa.BuilderOptions{
+			Progress: func(p marisa.BuildProgress) {
+				if len(phases) == 0 || phases[len(phases)-1] != p.Phase {
+					phases = append(phases, p.Phase)
+				}
+				if p.Memory == 0 {
+					t.Errorf("expected memory usage to be reported")
+				}
+			},
+		})
+		if act := b.Len(); act != len(testdata.Words) {
+			t.Errorf("expected %d keys, got %d", len(testdata.Words), act)
+		}
+		if act := b.MemoryUsage(); act == 0 {
+			t.Errorf("expected non-zero memory usage")
+		}
+		if _, err := b.Finish(context.Background(), marisa.Config{NumTries: -1}); !errors.Is(err, marisa.ErrInvalidConfig) {
+			t.Errorf("expected invalid config error, got %v", err)
+		}
+
+		trie, err := b.Finish(context.Background(), marisa.Config{})
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if buf, err := trie.MarshalBinary(); err != nil {
+			t.Fatalf("error: %v", err)
+		} else if !bytes.Equal(buf, expected) {
+			t.Errorf("incorrect dictionary")
+		}
+		if exp := []marisa.BuildPhase{marisa.BuildPhaseAdd, marisa.BuildPhaseBuild, marisa.BuildPhaseLoad}; !slices.Equal(phases, exp) {
+			t.Errorf("expected phases %v, got %v", exp, phases)
+		}
+
+		if err := b.Add("test", 1); !errors.Is(err, marisa.ErrClosed) {
+			t.Errorf("expected closed error, got %v", err)
+		}
+		if act := b.MemoryUsage(); act != 0 {
+			t.Errorf("expected zero memory usage after finishing, got %d", act)
+		}
+		if err := b.Close(); !errors.Is(err, marisa.ErrClosed) {
+			t.Errorf("expected closed error, got %v", err)
+		}
+	})
+	t.Run("Cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		b := newBuilder(t, marisa.BuilderOptions{})
+		if _, err := b.Finish(ctx, marisa.Config{}); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected cancellation error, got %v", err)
+		}
+	})
+	t.Run("CancelBuild", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		b := newBuilder(t, marisa.BuilderOptions{
+			Progress: func(p marisa.BuildProgress) {
+				if p.Phase == marisa.BuildPhaseBuild {
+					cancel()
+				}
+			},
+		})
+		if _, err := b.Finish(ctx, marisa.Config{}); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected cancellation error, got %v", err)
+		}
+		if err := b.Add("test", 1); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected builder to be unusable, got %v", err)
+		}
+	})
+	t.Run("LimitAdd", func(t *testing.T) {
+		b, err := marisa.NewBuilder(marisa.BuilderOptions{MemoryLimit: 1 << 20})
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		defer b.Close()
+		for _, word := range testdata.Words {
+			if err = b.Add(word, 1); err != nil {
+				break
+			}
+		}
+		if !errors.Is(err, marisa.ErrOutOfMemory) {
+			t.Fatalf("expected out of memory error, got %v", err)
+		}
+		if act := b.Len(); act == 0 || act == len(testdata.Words) {
+			t.Errorf("expected to fail part-way through, got %d keys", act)
+		}
+	})
+	t.Run("LimitBuild", func(t *testing.T) {
+		b := newBuilder(t, marisa.BuilderOptions{})
+		limit := b.MemoryUsage()
+		b.Close()
+
+		b = newBuilder(t, marisa.BuilderOptions{MemoryLimit: limit})
+		if _, err := b.Finish(context.Background(), marisa.Config{}); !errors.Is(err, marisa.ErrOutOfMemory) {
+			t.Errorf("expected out of memory error, got %v", err)
+		}
+	})
+	t.Run("LimitLoad", func(t *testing.T) {
+		var used uint64
+		b := newBuilder(t, marisa.BuilderOptions{
+			Progress: func(p marisa.BuildProgress) {
+				if p.Phase == marisa.BuildPhaseLoad {
+					used = p.Memory
+				}
+			},
+		})
+		trie, err := b.Finish(context.Background(), marisa.Config{})
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		size := trie.MemoryStats().Size
+		trie.Close()
+
+		// enough to build, but not to also load it
+		b = newBuilder(t, marisa.BuilderOptions{MemoryLimit: used + 1})
+		if _, err := b.Finish(context.Background(), marisa.Config{}); !errors.Is(err, marisa.ErrOutOfMemory) {
+			t.Errorf("expected out of memory error, got %v", err)
+		}
+
+		b = newBuilder(t, marisa.BuilderOptions{MemoryLimit: used + size})
+		if trie, err := b.Finish(context.Background(), marisa.Config{}); err != nil {
+			t.Errorf("expected build to fit in the limit, got %v", err)
+		} else {
+			trie.Close()
+		}
+	})
+}
@@ -124,11 +124,11 @@ func main() {
 		panic(err)
 	}
 
-	var (
-		keys    []string
-		weights []float32
-		total   int
-	)
+	ks, err := marisa.NewKeyset()
+	if err != nil {
+		panic(err)
+	}
+	var total int
 	for key, weight := range func(yield func(string, float32) bool) {
 		names := pflag.Args()
 		if len(names) == 0 {
@@ -156,9 +156,9 @@ func main() {
 						weight = float32(v)
 					}
 				}
-				keys = append(keys, key)
-				weights = append(weights, weight)
-				total += len(key)
+				if !yield(key, weight) {
+					return
+				}
 			}
 			if err := sc.Err(); err != nil {
 				// the original version doesn't handle read errors, but there's no point in not doing so
@@ -167,11 +167,12 @@ func main() {
 			}
 		}
 	} {
-		keys = append(keys, key)
-		weights = append(weights, weight)
+		if err := ks.Add(key, weight); err != nil {
+			panic(err)
+		}
 		total += len(key)
 	}
-	fmt.Printf("Number of keys: %d\n", len(keys))
+	fmt.Printf("Number of keys: %d\n", ks.Len())
 	fmt.Printf("Total length: %d\n", total)
 
 	fmt.Printf("------+----------+--------+--------+--------+--------+--------\n")
@@ -188,7 +189,7 @@ func main() {
 		fmt.Printf("%6d", numTries)
 
 		var trie marisa.Trie
-		benchmarkBuild(keys, weights, cfg, &trie)
+		benchmarkBuild(ks, cfg, &trie)
 
 		keyset, err := trie.Dump(-1)
 		if err != nil {
@@ -238,15 +239,9 @@ func printTimeInfo(n int) func() {
 	}
 }
 
-func benchmarkBuild(keyset []string, weights []float32, cfg marisa.Config, trie *marisa.Trie) {
-	defer printTimeInfo(len(keyset))()
-	if err := trie.BuildWeights(func(yield func(string, float32) bool) {
-		for i, key := range keyset {
-			if !yield(key, float32(weights[i])) {
-				return
-			}
-		}
-	}, cfg); err != nil {
+func benchmarkBuild(ks *marisa.Keyset, cfg marisa.Config, trie *marisa.Trie) {
+	defer printTimeInfo(ks.Len())()
+	if err := trie.BuildKeyset(ks, cfg); err != nil {
 		panic(err)
 	}
 	fmt.Printf(" %10d", trie.DiskSize())
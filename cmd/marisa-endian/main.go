@@ -0,0 +1,143 @@
+// Command marisa-endian converts dictionaries between the little-endian format
+// and the big-endian format written by the native tools on big-endian hosts.
+//
+// There is no equivalent native command.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/pgaskin/go-marisa"
+	"github.com/spf13/pflag"
+)
+
+var (
+	BigEndian    = pflag.BoolP("big-endian", "b", false, "convert a little-endian dictionary to big-endian")
+	LittleEndian = pflag.BoolP("little-endian", "l", false, "convert a big-endian dictionary to little-endian (default)")
+	WordSize     = pflag.IntP("word-size", "w", 64, "word size of the big-endian host [32, 64]")
+	Output       = pflag.StringP("output", "o", "", "write the dictionary to the file (default: stdout)")
+	Help         = pflag.BoolP("help", "h", false, "print this help")
+)
+
+func main() {
+	pflag.Parse()
+
+	if *Help {
+		fmt.Printf("usage: %s [options] [file]\n%s", os.Args[0], pflag.CommandLine.FlagUsages())
+		os.Exit(0)
+	}
+	if *BigEndian && *LittleEndian {
+		fmt.Fprintf(os.Stderr, "error: options '-b' and '-l' are exclusive\n")
+		os.Exit(1)
+	}
+	if *WordSize != 32 && *WordSize != 64 {
+		fmt.Fprintf(os.Stderr, "error: option '-w' with an invalid argument: %d\n", *WordSize)
+		os.Exit(2)
+	}
+	if pflag.NArg() > 1 {
+		fmt.Fprintf(os.Stderr, "error: more than one dictionaries are specified\n")
+		os.Exit(3)
+	}
+
+	name := "-"
+	if pflag.NArg() != 0 {
+		name = pflag.Arg(0)
+	}
+
+	var (
+		buf []byte
+		err error
+	)
+	if name == "-" {
+		buf, err = io.ReadAll(os.Stdin)
+	} else {
+		buf, err = os.ReadFile(name)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: failed to read dictionary %q: %v\n", name, err)
+		os.Exit(10)
+	}
+
+	// convert the dictionary, then verify it against the input
+	out := slices.Clone(buf)
+	if *BigEndian {
+		err = marisa.ToBigEndian(out, *WordSize)
+	} else {
+		err = marisa.FromBigEndian(out, *WordSize)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: failed to convert dictionary %q: %v\n", name, err)
+		os.Exit(20)
+	}
+	if *BigEndian {
+		err = verify(buf, out, *WordSize)
+	} else {
+		err = verify(out, buf, *WordSize)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: failed to verify converted dictionary %q: %v\n", name, err)
+		os.Exit(21)
+	}
+
+	if *Output != "" && *Output != "-" {
+		if err := os.WriteFile(*Output, out, 0666); err != nil {
+			fmt.Fprintf(os.Stderr, "error: failed to write a dictionary to file: %v\n", err)
+			os.Exit(30)
+		}
+	} else {
+		if _, err := os.Stdout.Write(out); err != nil {
+			fmt.Fprintf(os.Stderr, "error: failed to write a dictionary to standard output: %v\n", err)
+			os.Exit(33)
+		}
+	}
+}
+
+// verify checks that the little-endian dictionary le and the big-endian
+// dictionary be are conversions of each other: converting le to big-endian must
+// produce be exactly, and the dictionary converted from be must dump the same
+// keys as le, each of which must look up to the same ID.
+func verify(le, be []byte, wordSize int) error {
+	fromBE := slices.Clone(be)
+	if err := marisa.FromBigEndian(fromBE, wordSize); err != nil {
+		return err
+	}
+	toBE := slices.Clone(le)
+	if err := marisa.ToBigEndian(toBE, wordSize); err != nil {
+		return err
+	}
+	if !bytes.Equal(toBE, be) {
+		return fmt.Errorf("converting back does not produce the input")
+	}
+
+	a, err := marisa.New(le)
+	if err != nil {
+		return err
+	}
+	b, err := marisa.New(fromBE)
+	if err != nil {
+		return err
+	}
+	ak, err := a.Dump(-1)
+	if err != nil {
+		return err
+	}
+	bk, err := b.Dump(-1)
+	if err != nil {
+		return err
+	}
+	if !slices.Equal(ak, bk) {
+		return fmt.Errorf("converted dictionary has different keys than the input")
+	}
+	for _, k := range ak {
+		if id, ok, err := b.Lookup(k.Key); err != nil {
+			return err
+		} else if !ok || id != k.ID {
+			return fmt.Errorf("key %q has id %d in the input, but %d after converting", k.Key, k.ID, id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+// Command marisa-info prints information about dictionaries, including the size
+// of each component of each trie level.
+//
+// There is no equivalent native command.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/pgaskin/go-marisa"
+	"github.com/spf13/pflag"
+)
+
+var (
+	MmapDictionary = pflag.BoolP("mmap-dictionary", "m", false, "use memory-mapped i/o to load a dictionary (exclusive with -r)")
+	ReadDictionary = pflag.BoolP("read-dictionary", "r", false, "read an entire dictionary into memory (exclusive with -m)")
+	Help           = pflag.BoolP("help", "h", false, "print this help")
+)
+
+func main() {
+	pflag.Parse()
+
+	if *Help {
+		fmt.Printf("usage: %s [options] file...\n%s", os.Args[0], pflag.CommandLine.FlagUsages())
+		os.Exit(0)
+	}
+	if *MmapDictionary && *ReadDictionary {
+		fmt.Fprintf(os.Stderr, "error: options '-m' and '-r' are exclusive\n")
+		os.Exit(1)
+	}
+
+	names := pflag.Args()
+	if len(names) == 0 {
+		names = []string{"-"}
+	}
+	for i, name := range names {
+		if i != 0 {
+			fmt.Println()
+		}
+		if code := info(name); code != 0 {
+			os.Exit(code)
+		}
+	}
+}
+
+func info(name string) int {
+	var trie marisa.Trie
+	if name != "-" {
+		fmt.Printf("input: %s\n", name)
+		if !*ReadDictionary {
+			if err := mmap(&trie, name); err != nil {
+				if *MmapDictionary || !errors.Is(err, errors.ErrUnsupported) {
+					fmt.Fprintf(os.Stderr, "error: failed to mmap dictionary %q: %v\n", name, err)
+					return 10
+				} else if err := load(&trie, name); err != nil {
+					fmt.Fprintf(os.Stderr, "error: failed to load dictionary %q: %v\n", name, err)
+					return 11
+				}
+			}
+		} else {
+			if err := load(&trie, name); err != nil {
+				fmt.Fprintf(os.Stderr, "error: failed to load dictionary %q: %v\n", name, err)
+				return 11
+			}
+		}
+	} else {
+		fmt.Printf("input: <stdin>\n")
+		if _, err := trie.ReadFrom(os.Stdin); err != nil {
+			fmt.Fprintf(os.Stderr, "error: failed to load dictionary %q: %v\n", name, err)
+			return 12
+		}
+	}
+	defer trie.Close()
+
+	levels, err := trie.SizeBreakdown()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: failed to get size breakdown of dictionary %q: %v\n", name, err)
+		return 20
+	}
+
+	fmt.Printf("#keys: %d\n", trie.Size())
+	fmt.Printf("#tries: %d\n", trie.NumTries())
+	fmt.Printf("#nodes: %d\n", trie.NumNodes())
+	fmt.Printf("size: %d\n", trie.DiskSize())
+	fmt.Printf("total size: %d\n", trie.TotalSize())
+	fmt.Printf("config: %s\n", trie.Config())
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "level\tlouds\tterminal\tlink\tbases\textras\ttail\tcache\ttotal\t\n")
+	for i, s := range levels {
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t\n", i, s.Louds, s.TerminalFlags, s.LinkFlags, s.Bases, s.Extras, s.Tail, s.Cache, s.Total())
+	}
+	if err := tw.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: failed to write stdout: %v\n", err)
+		return 21
+	}
+	return 0
+}
+
+func mmap(trie *marisa.Trie, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err := trie.MapFile(f, 0, fi.Size()); err != nil {
+		return err
+	}
+	return nil
+}
+
+func load(trie *marisa.Trie, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := trie.ReadFrom(f); err != nil {
+		return err
+	}
+	return nil
+}
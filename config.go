@@ -0,0 +1,157 @@
+package marisa
+
+import (
+	"encoding"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	_ fmt.Stringer             = Config{}
+	_ encoding.TextMarshaler   = Config{}
+	_ encoding.TextUnmarshaler = (*Config)(nil)
+	_ flag.Value               = (*Config)(nil)
+)
+
+// String returns the config in the text format (see [Config.MarshalText]).
+func (c Config) String() string {
+	b, _ := c.MarshalText()
+	return string(b)
+}
+
+// Set parses s as the text format (see [Config.UnmarshalText]). It implements
+// [flag.Value].
+func (c *Config) Set(s string) error {
+	return c.UnmarshalText([]byte(s))
+}
+
+// MarshalText encodes the config as comma-separated key=value pairs (e.g.,
+// num_tries=3,cache=normal,tail=text,order=weight). Unspecified
+// options are omitted.
+func (c Config) MarshalText() ([]byte, error) {
+	var b []byte
+	add := func(k, v string) {
+		if len(b) != 0 {
+			b = append(b, ',')
+		}
+		b = append(b, k...)
+		b = append(b, '=')
+		b = append(b, v...)
+	}
+	if c.NumTries != 0 {
+		add("num_tries", strconv.Itoa(c.NumTries))
+	}
+	if c.CacheLevel != 0 {
+		add("cache", c.CacheLevel.String())
+	}
+	if c.TailMode != 0 {
+		add("tail", c.TailMode.String())
+	}
+	if c.NodeOrder != 0 {
+		add("order", c.NodeOrder.String())
+	}
+	if _, ok := configFlags(c); !ok {
+		return nil, errorKind(ErrInvalidConfig, fmt.Errorf("cannot marshal %q", b))
+	}
+	return b, nil
+}
+
+// UnmarshalText decodes the text format (see [Config.MarshalText]), replacing
+// the entire config. Options which aren't specified are left unspecified.
+// Whitespace around keys and values is ignored.
+func (c *Config) UnmarshalText(b []byte) error {
+	var cfg Config
+	for kv := range strings.SplitSeq(string(b), ",") {
+		if strings.TrimSpace(kv) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return errorKind(ErrInvalidConfig, fmt.Errorf("missing value for %q", strings.TrimSpace(kv)))
+		}
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+
+		var err error
+		switch k {
+		case "num_tries":
+			cfg.NumTries, err = strconv.Atoi(v)
+			if err == nil {
+				if _, ok := numTriesFlag(cfg.NumTries); !ok || cfg.NumTries == 0 {
+					err = errors.New("out of range")
+				}
+			}
+		case "cache":
+			cfg.CacheLevel, err = parseConfigValue(v, HugeCache, LargeCache, NormalCache, SmallCache, TinyCache)
+		case "tail":
+			cfg.TailMode, err = parseConfigValue(v, TextTail, BinaryTail)
+		case "order":
+			cfg.NodeOrder, err = parseConfigValue(v, LabelOrder, WeightOrder)
+		default:
+			return errorKind(ErrInvalidConfig, fmt.Errorf("unknown option %q", k))
+		}
+		if err != nil {
+			return errorKind(ErrInvalidConfig, fmt.Errorf("invalid %s %q: %w", k, v, err))
+		}
+	}
+	*c = cfg
+	return nil
+}
+
+// parseConfigValue returns the value with the string representation s.
+func parseConfigValue[T fmt.Stringer](s string, values ...T) (T, error) {
+	for _, v := range values {
+		if v.String() == s {
+			return v, nil
+		}
+	}
+	var zero T
+	return zero, errors.New("unknown value")
+}
+
+// Config returns the configuration of the dictionary. If the trie is not
+// initialized, it returns the zero value.
+//
+// Since the cache level isn't stored in the dictionary, it is inferred from
+// the size of the cache. If multiple levels would result in the same cache
+// size (e.g., for small dictionaries), the default one is preferred, then the
+// largest one, since they would all build the same dictionary. If the cache
+// size can't be read, the cache level is left unspecified.
+func (t *Trie) Config() Config {
+	if t.mod == nil {
+		return Config{}
+	}
+	if t.cacheLevel == 0 && t.err() == nil {
+		if s, err := t.levelSize(0); err == nil {
+			t.cacheLevel = inferCacheLevel(t.size, uint64(s.Cache/cacheEntrySize))
+		}
+	}
+	return Config{
+		NumTries:   int(t.numTries),
+		CacheLevel: t.cacheLevel,
+		TailMode:   t.tailMode,
+		NodeOrder:  t.nodeOrder,
+	}
+}
+
+// cacheEntrySize is the size of marisa::grimoire::trie::Cache.
+const cacheEntrySize = 12
+
+// inferCacheLevel returns the cache level which would result in a cache of the
+// specified size for the first trie, or zero if none would. This must match
+// marisa::grimoire::trie::LoudsTrie::reserve_cache.
+func inferCacheLevel(numKeys uint32, size uint64) CacheLevel {
+	for _, level := range []CacheLevel{NormalCache, HugeCache, LargeCache, SmallCache, TinyCache} {
+		f, _ := cacheLevelFlag(level)
+		n := uint64(256)
+		for n < uint64(numKeys)/uint64(f) {
+			n *= 2
+		}
+		if n == size {
+			return level
+		}
+	}
+	return 0
+}
@@ -0,0 +1,150 @@
+package marisa_test
+
+import (
+	"errors"
+	"flag"
+	"slices"
+	"testing"
+
+	"github.com/pgaskin/go-marisa"
+	"github.com/pgaskin/go-marisa/testdata"
+)
+
+func TestTrieConfig(t *testing.T) {
+	var trie marisa.Trie
+	if act := trie.Config(); act != (marisa.Config{}) {
+		t.Errorf("expected zero config for uninitialized trie, got %v", act)
+	}
+	for _, level := range []marisa.CacheLevel{marisa.HugeCache, marisa.LargeCache, marisa.NormalCache, marisa.SmallCache, marisa.TinyCache} {
+		t.Run(level.String(), func(t *testing.T) {
+			cfg := marisa.Config{
+				NumTries:   2,
+				CacheLevel: level,
+				TailMode:   marisa.BinaryTail,
+				NodeOrder:  marisa.LabelOrder,
+			}
+			var built marisa.Trie
+			if err := built.Build(slices.Values(testdata.Words), cfg); err != nil {
+				t.Fatalf("error: %v", err)
+			}
+			defer built.Close()
+
+			if act := built.Config(); act != cfg {
+				t.Errorf("built: expected %v, got %v", cfg, act)
+			}
+
+			buf, err := built.MarshalBinary()
+			if err != nil {
+				t.Fatalf("error: %v", err)
+			}
+			var loaded marisa.Trie
+			if err := loaded.UnmarshalBinary(buf); err != nil {
+				t.Fatalf("error: %v", err)
+			}
+			defer loaded.Close()
+
+			// the cache level must be inferred
+			if act := loaded.Config(); act != cfg {
+				t.Errorf("loaded: expected %v, got %v", cfg, act)
+			}
+		})
+	}
+	t.Run("Default", func(t *testing.T) {
+		trie := mustWordsTrie()
+		defer trie.Close()
+
+		exp := marisa.Config{
+			NumTries:   3,
+			CacheLevel: marisa.NormalCache,
+			TailMode:   marisa.TextTail,
+			NodeOrder:  marisa.WeightOrder,
+		}
+		if act := trie.Config(); act != exp {
+			t.Errorf("expected %v, got %v", exp, act)
+		}
+		trie.Close()
+		if act := trie.Config(); act.CacheLevel != marisa.NormalCache {
+			t.Errorf("expected cache level to be remembered after closing, got %v", act)
+		}
+	})
+}
+
+func TestConfigText(t *testing.T) {
+	for _, tc := range []struct {
+		Text string
+		Cfg  marisa.Config
+		Err  bool
+		Exp  string // if different from Text
+	}{
+		{Text: "", Cfg: marisa.Config{}},
+		{Text: "num_tries=3,cache=normal,tail=text,order=weight", Cfg: marisa.Config{NumTries: 3, CacheLevel: marisa.NormalCache, TailMode: marisa.TextTail, NodeOrder: marisa.WeightOrder}},
+		{Text: "num_tries=127,cache=tiny,tail=binary,order=label", Cfg: marisa.Config{NumTries: 127, CacheLevel: marisa.TinyCache, TailMode: marisa.BinaryTail, NodeOrder: marisa.LabelOrder}},
+		{Text: " order = label , num_tries=1,", Cfg: marisa.Config{NumTries: 1, NodeOrder: marisa.LabelOrder}, Exp: "num_tries=1,order=label"},
+		{Text: "cache=huge", Cfg: marisa.Config{CacheLevel: marisa.HugeCache}},
+		{Text: "merge=max", Err: true},
+		{Text: "num_tries=0", Err: true},
+		{Text: "num_tries=128", Err: true},
+		{Text: "num_tries=x", Err: true},
+		{Text: "cache=unknown", Err: true},
+		{Text: "tail=", Err: true},
+		{Text: "order", Err: true},
+		{Text: "size=1", Err: true},
+	} {
+		t.Run(tc.Text, func(t *testing.T) {
+			var cfg marisa.Config
+			if err := cfg.UnmarshalText([]byte(tc.Text)); tc.Err {
+				if !errors.Is(err, marisa.ErrInvalidConfig) {
+					t.Fatalf("expected invalid config error, got %v", err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("error: %v", err)
+			}
+			if cfg != tc.Cfg {
+				t.Errorf("expected %#v, got %#v", tc.Cfg, cfg)
+			}
+			exp := tc.Text
+			if tc.Exp != "" {
+				exp = tc.Exp
+			}
+			if buf, err := cfg.MarshalText(); err != nil {
+				t.Errorf("marshal: %v", err)
+			} else if string(buf) != exp {
+				t.Errorf("marshal: expected %q, got %q", exp, buf)
+			}
+		})
+	}
+	t.Run("Replace", func(t *testing.T) {
+		cfg := marisa.Config{NumTries: 5, TailMode: marisa.BinaryTail}
+		if err := cfg.UnmarshalText([]byte("order=label")); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if exp := (marisa.Config{NodeOrder: marisa.LabelOrder}); cfg != exp {
+			t.Errorf("expected %#v, got %#v", exp, cfg)
+		}
+		if err := cfg.UnmarshalText([]byte("order=label,tail=x")); err == nil {
+			t.Errorf("expected error")
+		} else if exp := (marisa.Config{NodeOrder: marisa.LabelOrder}); cfg != exp {
+			t.Errorf("expected config to be unchanged on error, got %#v", cfg)
+		}
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		if _, err := (marisa.Config{NumTries: -1}).MarshalText(); !errors.Is(err, marisa.ErrInvalidConfig) {
+			t.Errorf("expected invalid config error, got %v", err)
+		}
+	})
+	t.Run("Flag", func(t *testing.T) {
+		var cfg marisa.Config
+		fs := flag.NewFlagSet("", flag.ContinueOnError)
+		fs.Var(&cfg, "config", "dictionary config")
+		if err := fs.Parse([]string{"-config", "num_tries=4,order=label"}); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if exp := (marisa.Config{NumTries: 4, NodeOrder: marisa.LabelOrder}); cfg != exp {
+			t.Errorf("expected %#v, got %#v", exp, cfg)
+		}
+		if act := fs.Lookup("config").Value.String(); act != "num_tries=4,order=label" {
+			t.Errorf("incorrect flag value %q", act)
+		}
+	})
+}
@@ -0,0 +1,48 @@
+package marisa
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/pgaskin/go-marisa/internal/layout"
+)
+
+// FromBigEndian converts a big-endian dictionary (i.e., one generated by the
+// native tools on a big-endian host) in-place to the little-endian format
+// supported by this library.
+//
+// The word size is the size in bits of the native word on the host which wrote
+// the dictionary (i.e., 64 for s390x or ppc64, 32 for ppc). If zero, it
+// defaults to 64.
+func FromBigEndian(b []byte, wordSize int) error {
+	return swapEndian(b, binary.BigEndian, wordSize)
+}
+
+// ToBigEndian converts a little-endian dictionary in-place to the big-endian
+// format used by the native tools on big-endian hosts with the specified word
+// size (see [FromBigEndian]).
+func ToBigEndian(b []byte, wordSize int) error {
+	return swapEndian(b, binary.LittleEndian, wordSize)
+}
+
+func swapEndian(b []byte, order binary.ByteOrder, wordSize int) error {
+	var unit int
+	switch wordSize {
+	case 0, 64:
+		unit = 8
+	case 32:
+		unit = 4
+	default:
+		return errors.New("invalid word size")
+	}
+	l, err := layout.Parse(bytes.NewReader(b), int64(len(b)), order, unit)
+	if err != nil {
+		return corruptLayoutError(err, int64(len(b)))
+	}
+	if n := l.IOSize(); n != int64(len(b)) {
+		return corrupt(n, "trailing data after dictionary", nil)
+	}
+	layout.Swap(b, l)
+	return nil
+}
@@ -0,0 +1,78 @@
+package marisa_test
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+
+	"github.com/pgaskin/go-marisa"
+	"github.com/pgaskin/go-marisa/testdata"
+)
+
+func TestEndian(t *testing.T) {
+	testEndian(t, "Words", slices.Values(testdata.Words), marisa.Config{})
+	testEndian(t, "Go125", slices.Values(testdata.Go125), marisa.Config{NumTries: 5, TailMode: marisa.BinaryTail, NodeOrder: marisa.LabelOrder})
+	testEndian(t, "Empty", slices.Values([]string{}), marisa.Config{})
+	testEndian(t, "Blank", slices.Values([]string{""}), marisa.Config{})
+}
+
+func testEndian(t *testing.T, name string, keys func(func(string) bool), cfg marisa.Config) {
+	t.Run(name, func(t *testing.T) {
+		var trie marisa.Trie
+		if err := trie.Build(keys, cfg); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		expected, err := trie.MarshalBinary()
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		dump, err := trie.Dump(-1)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+
+		for _, wordSize := range []int{0, 32, 64} {
+			be := slices.Clone(expected)
+			if err := marisa.ToBigEndian(be, wordSize); err != nil {
+				t.Fatalf("word size %d: error: %v", wordSize, err)
+			}
+			if bytes.Equal(be, expected) {
+				t.Errorf("word size %d: big-endian dictionary should differ", wordSize)
+			}
+			if err := new(marisa.Trie).UnmarshalBinary(be); err == nil {
+				t.Errorf("word size %d: big-endian dictionary should not load directly", wordSize)
+			}
+
+			le := slices.Clone(be)
+			if err := marisa.FromBigEndian(le, wordSize); err != nil {
+				t.Fatalf("word size %d: error: %v", wordSize, err)
+			}
+			if !bytes.Equal(le, expected) {
+				t.Errorf("word size %d: round-trip differs", wordSize)
+			}
+
+			trie, err := marisa.New(le)
+			if err != nil {
+				t.Fatalf("word size %d: error: %v", wordSize, err)
+			}
+			if act, err := trie.Dump(-1); err != nil {
+				t.Fatalf("word size %d: error: %v", wordSize, err)
+			} else if !slices.Equal(act, dump) {
+				t.Errorf("word size %d: round-trip dump differs", wordSize)
+			}
+		}
+
+		if err := marisa.FromBigEndian(slices.Clone(expected), 0); err == nil {
+			t.Errorf("converting a little-endian dictionary from big-endian should fail")
+		}
+		if err := marisa.ToBigEndian(expected[:len(expected)-1], 0); err == nil {
+			t.Errorf("converting a truncated dictionary should fail")
+		}
+		if err := marisa.ToBigEndian(append(slices.Clone(expected), 0), 0); err == nil {
+			t.Errorf("converting a dictionary with trailing data should fail")
+		}
+		if err := marisa.ToBigEndian(slices.Clone(expected), 16); err == nil {
+			t.Errorf("invalid word size should fail")
+		}
+	})
+}
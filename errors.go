@@ -0,0 +1,166 @@
+package marisa
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pgaskin/go-marisa/internal/cxxerr"
+)
+
+// These are the kinds of errors returned by this package. Use [errors.Is] to
+// check for them. Errors from the underlying reader/writer are returned as-is.
+var (
+	// ErrCorrupt is returned for dictionaries which are truncated, malformed,
+	// or internally inconsistent. Truncated dictionaries also match
+	// [io.ErrUnexpectedEOF].
+	ErrCorrupt = errors.New("corrupt dictionary")
+
+	// ErrTooLarge is returned when a dictionary or key exceeds the size limits
+	// of MARISA or the current platform.
+	ErrTooLarge = errors.New("dictionary too large")
+
+	// ErrNotInitialized is returned when using a [Trie] which has not been
+	// built or loaded.
+	ErrNotInitialized = errors.New("dictionary not initialized")
+
+	// ErrClosed is returned when using a [Trie] after [Trie.Close].
+	ErrClosed = errors.New("dictionary closed")
+
+	// ErrInvalidConfig is returned when a [Config] is invalid.
+	ErrInvalidConfig = errors.New("invalid config")
+
+	// ErrOutOfMemory is returned when module memory cannot be allocated.
+	ErrOutOfMemory = errors.New("out of memory")
+
+	// ErrInternal is returned for unexpected failures within MARISA or this
+	// package (i.e., bugs).
+	ErrInternal = errors.New("internal error")
+)
+
+// Error is an error returned by this package. It matches its Kind and Err with
+// [errors.Is].
+type Error struct {
+	Kind error  // one of the Err* values
+	Type string // C++ exception type (e.g., std::runtime_error), if any
+	What string // C++ exception message, if any
+	Err  error  // underlying error, if any
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Kind.Error())
+	if e.Err != nil {
+		b.WriteString(": ")
+		b.WriteString(e.Err.Error())
+	}
+	return b.String()
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// CorruptError describes a problem found in a dictionary. It is returned
+// wrapped in an [*Error] with the kind [ErrCorrupt].
+type CorruptError struct {
+	Offset int64  // offset of the problematic field, or -1 if unknown
+	Field  string // name of the problematic field, if known
+	Reason string // description of the problem
+	Err    error  // underlying error, if any
+}
+
+func (e *CorruptError) Error() string {
+	var b []byte
+	b = append(b, e.Field...)
+	if e.Offset >= 0 {
+		if len(b) != 0 {
+			b = append(b, ' ')
+		}
+		b = append(b, "at offset "...)
+		b = strconv.AppendInt(b, e.Offset, 10)
+	}
+	if e.Reason != "" {
+		if len(b) != 0 {
+			b = append(b, ": "...)
+		}
+		b = append(b, e.Reason...)
+	}
+	if e.Err != nil {
+		if len(b) != 0 {
+			b = append(b, ": "...)
+		}
+		b = append(b, e.Err.Error()...)
+	}
+	return string(b)
+}
+
+func (e *CorruptError) Unwrap() error {
+	return e.Err
+}
+
+// corrupt returns a new [ErrCorrupt] error.
+func corrupt(offset int64, reason string, err error) error {
+	return &Error{Kind: ErrCorrupt, Err: &CorruptError{Offset: offset, Reason: reason, Err: err}}
+}
+
+// errorKind returns a new error of the specified kind.
+func errorKind(kind error, err error) error {
+	return &Error{Kind: kind, Err: err}
+}
+
+// internalError returns a new [ErrInternal] error.
+func internalError(msg string) error {
+	return errorKind(ErrInternal, errors.New(msg))
+}
+
+// wrapException maps a C++ exception into an [*Error]. Other errors are
+// returned as-is.
+func wrapException(err error) error {
+	var ex *cxxerr.Exception
+	if !errors.As(err, &ex) {
+		return err
+	}
+	e := &Error{
+		Type: ex.Type(),
+		What: ex.What(),
+		Err:  err,
+	}
+	switch {
+	case errors.Is(ex, cxxerr.BadAlloc):
+		e.Kind = ErrOutOfMemory
+	case errors.Is(ex, cxxerr.LengthError):
+		e.Kind = ErrTooLarge
+	case errors.Is(ex, cxxerr.RuntimeError):
+		// marisa throws runtime_error when it finds a problem with the data
+		e.Kind = ErrCorrupt
+		if strings.Contains(ex.What(), "size > avail_") || strings.Contains(ex.What(), "!stream_->read") {
+			e.Err = truncatedError{err}
+		}
+	case errors.Is(ex, cxxerr.InvalidArgument):
+		// marisa throws invalid_argument for bad config flags, and we validate
+		// the config before building, so it must have come from the data
+		e.Kind = ErrCorrupt
+	default:
+		e.Kind = ErrInternal
+	}
+	return e
+}
+
+// truncatedError wraps an exception thrown for a truncated dictionary so it
+// also matches [io.ErrUnexpectedEOF].
+type truncatedError struct {
+	err error
+}
+
+func (e truncatedError) Error() string {
+	return e.err.Error()
+}
+
+func (e truncatedError) Unwrap() []error {
+	return []error{io.ErrUnexpectedEOF, e.err}
+}
@@ -0,0 +1,85 @@
+package marisa_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/pgaskin/go-marisa"
+)
+
+func TestErrors(t *testing.T) {
+	expected := mustWordsTrieData()
+
+	assertKind := func(t *testing.T, name string, err, kind error) *marisa.Error {
+		t.Helper()
+		var merr *marisa.Error
+		if !errors.Is(err, kind) {
+			t.Errorf("%s: expected error matching %q, got %v", name, kind, err)
+		} else if !errors.As(err, &merr) {
+			t.Errorf("%s: expected *Error, got %T", name, err)
+		} else if merr.Kind != kind {
+			t.Errorf("%s: expected kind %q, got %q", name, kind, merr.Kind)
+		}
+		return merr
+	}
+
+	t.Run("Truncated", func(t *testing.T) {
+		var trie marisa.Trie
+		err := trie.UnmarshalBinary(expected[:len(expected)/2])
+		if merr := assertKind(t, "UnmarshalBinary", err, marisa.ErrCorrupt); merr != nil {
+			if merr.Type == "" || merr.What == "" {
+				t.Errorf("expected exception type and message to be set")
+			}
+		}
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("expected error to match io.ErrUnexpectedEOF, got %v", err)
+		}
+
+		_, err = trie.ReadFrom(bytes.NewReader(expected[:len(expected)/2]))
+		assertKind(t, "ReadFrom", err, marisa.ErrCorrupt)
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("expected error to match io.ErrUnexpectedEOF, got %v", err)
+		}
+	})
+
+	t.Run("Junk", func(t *testing.T) {
+		var trie marisa.Trie
+		err := trie.UnmarshalBinary(filled(byte(0xFF), 4096))
+		assertKind(t, "UnmarshalBinary", err, marisa.ErrCorrupt)
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("expected error to not match io.ErrUnexpectedEOF")
+		}
+	})
+
+	t.Run("InvalidConfig", func(t *testing.T) {
+		var trie marisa.Trie
+		err := trie.Build(slices.Values([]string{"a"}), marisa.Config{NumTries: -1})
+		assertKind(t, "Build", err, marisa.ErrInvalidConfig)
+	})
+
+	t.Run("NotInitialized", func(t *testing.T) {
+		var trie marisa.Trie
+		_, err := trie.MarshalBinary()
+		assertKind(t, "MarshalBinary", err, marisa.ErrNotInitialized)
+		_, err = trie.WriteTo(io.Discard)
+		assertKind(t, "WriteTo", err, marisa.ErrNotInitialized)
+	})
+
+	t.Run("Writer", func(t *testing.T) {
+		var trie marisa.Trie
+		if err := trie.UnmarshalBinary(expected); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		_, err := trie.WriteTo(&limitedWriter{W: io.Discard, N: 100})
+		if !errors.Is(err, errWriteLimit) {
+			t.Errorf("expected writer error, got %v", err)
+		}
+		var merr *marisa.Error
+		if errors.As(err, &merr) {
+			t.Errorf("expected writer error to be returned as-is, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,126 @@
+package marisa
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+)
+
+// executableMagic identifies a dictionary appended to an executable.
+const executableMagic = "MARISA\x00\x01"
+
+// ExecutableTrailerSize is the size of the trailer following a dictionary
+// appended to an executable.
+const ExecutableTrailerSize = 8 + len(executableMagic)
+
+// ErrNoExecutablePayload is returned by [OpenExecutable] and
+// [OpenExecutableSection] if the executable does not contain a dictionary.
+var ErrNoExecutablePayload = errors.New("executable does not contain a dictionary")
+
+// AppendExecutablePayload copies the dictionary in r to w, followed by a
+// trailer so it can be found by [OpenExecutable] when w is appended to an
+// executable. It returns the number of bytes written.
+func AppendExecutablePayload(w io.Writer, r io.Reader) (int64, error) {
+	n, err := io.Copy(w, r)
+	if err != nil {
+		return n, err
+	}
+	var trailer [ExecutableTrailerSize]byte
+	binary.LittleEndian.PutUint64(trailer[:], uint64(n))
+	copy(trailer[8:], executableMagic)
+	m, err := w.Write(trailer[:])
+	return n + int64(m), err
+}
+
+// OpenExecutable opens a dictionary appended to the current executable with
+// [AppendExecutablePayload]. It is memory-mapped if well-supported on the
+// current platform, so it isn't copied, and the pages are shared between
+// processes. If the executable does not have an appended dictionary,
+// [ErrNoExecutablePayload] is returned.
+func OpenExecutable() (*Trie, error) {
+	f, err := openExecutable()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if size < int64(ExecutableTrailerSize) {
+		return nil, ErrNoExecutablePayload
+	}
+
+	var trailer [ExecutableTrailerSize]byte
+	if _, err := f.ReadAt(trailer[:], size-int64(len(trailer))); err != nil {
+		return nil, err
+	}
+	if string(trailer[8:]) != executableMagic {
+		return nil, ErrNoExecutablePayload
+	}
+	length := binary.LittleEndian.Uint64(trailer[:])
+	if length > uint64(size-int64(len(trailer))) {
+		return nil, fmt.Errorf("invalid executable trailer: payload size %d larger than executable", length)
+	}
+	return openSection(f, size-int64(len(trailer))-int64(length), int64(length))
+}
+
+// OpenExecutableSection opens a dictionary stored in the named ELF section of
+// the current executable (e.g., one added with objcopy --add-section). It is
+// memory-mapped if well-supported on the current platform. If the executable
+// does not have the section, [ErrNoExecutablePayload] is returned.
+func OpenExecutableSection(name string) (*Trie, error) {
+	f, err := openExecutable()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ef, err := elf.NewFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("read executable: %w", err)
+	}
+	s := ef.Section(name)
+	if s == nil || s.Type == elf.SHT_NOBITS {
+		return nil, ErrNoExecutablePayload
+	}
+	if s.Flags&elf.SHF_COMPRESSED != 0 {
+		return nil, fmt.Errorf("executable section %q is compressed", name)
+	}
+	return openSection(f, int64(s.Offset), int64(s.Size))
+}
+
+// openExecutable opens the current executable.
+func openExecutable() (*os.File, error) {
+	if runtime.GOOS == "linux" {
+		// this still works if the executable was replaced or deleted
+		if f, err := os.Open("/proc/self/exe"); err == nil {
+			return f, nil
+		}
+	}
+	name, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(name)
+}
+
+// openSection opens a dictionary from part of a file, mapping it if
+// well-supported on the current platform.
+func openSection(f *os.File, offset, length int64) (*Trie, error) {
+	var t Trie
+	if mmapTested() {
+		if err := t.mapFile(f, offset, length, 0, nil); err == nil {
+			return &t, nil
+		}
+	}
+	if _, err := t.readFrom(io.NewSectionReader(f, offset, length), length); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
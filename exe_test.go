@@ -0,0 +1,112 @@
+package marisa_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/pgaskin/go-marisa"
+)
+
+// testExecutableEnv is set when the test binary is re-executed by
+// TestExecutable with an embedded dictionary.
+const testExecutableEnv = "MARISA_TEST_EXECUTABLE"
+
+func TestExecutable(t *testing.T) {
+	expected := mustWordsTrieData()
+
+	if mode := os.Getenv(testExecutableEnv); mode != "" {
+		var (
+			trie *marisa.Trie
+			err  error
+		)
+		if mode == "appended" {
+			trie, err = marisa.OpenExecutable()
+		} else {
+			trie, err = marisa.OpenExecutableSection(mode)
+		}
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if buf, err := trie.MarshalBinary(); err != nil {
+			t.Fatalf("error: %v", err)
+		} else if !bytes.Equal(buf, expected) {
+			t.Fatalf("round-trip failed")
+		}
+		return
+	}
+
+	if testing.Short() {
+		t.SkipNow()
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		t.Skipf("cannot get executable: %v", err)
+	}
+	exe, err := os.ReadFile(self)
+	if err != nil {
+		t.Skipf("cannot read executable: %v", err)
+	}
+
+	run := func(t *testing.T, name, mode string) {
+		t.Helper()
+		cmd := exec.Command(name, "-test.run=^TestExecutable$", "-test.v")
+		cmd.Env = append(os.Environ(), testExecutableEnv+"="+mode)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Errorf("error: %v\n%s", err, out)
+		}
+	}
+
+	t.Run("None", func(t *testing.T) {
+		if _, err := marisa.OpenExecutable(); !errors.Is(err, marisa.ErrNoExecutablePayload) {
+			t.Errorf("expected no payload error, got %v", err)
+		}
+		if runtime.GOOS == "linux" {
+			if _, err := marisa.OpenExecutableSection(".marisa"); !errors.Is(err, marisa.ErrNoExecutablePayload) {
+				t.Errorf("expected no payload error, got %v", err)
+			}
+		}
+	})
+
+	t.Run("Appended", func(t *testing.T) {
+		name := filepath.Join(t.TempDir(), "appended.exe")
+		var buf bytes.Buffer
+		buf.Write(exe)
+		if n, err := marisa.AppendExecutablePayload(&buf, bytes.NewReader(expected)); err != nil {
+			t.Fatalf("error: %v", err)
+		} else if n != int64(len(expected)+marisa.ExecutableTrailerSize) {
+			t.Errorf("incorrect size %d", n)
+		}
+		if err := os.WriteFile(name, buf.Bytes(), 0777); err != nil {
+			t.Fatal(err)
+		}
+		run(t, name, "appended")
+	})
+
+	t.Run("Section", func(t *testing.T) {
+		if runtime.GOOS != "linux" {
+			t.Skipf("not an elf platform")
+		}
+		objcopy, err := exec.LookPath("objcopy")
+		if err != nil {
+			t.Skipf("objcopy not found")
+		}
+		dict := filepath.Join(t.TempDir(), "words.dat")
+		if err := os.WriteFile(dict, expected, 0666); err != nil {
+			t.Fatal(err)
+		}
+		name := filepath.Join(t.TempDir(), "section.exe")
+		if out, err := exec.Command(objcopy, "--add-section", ".marisa="+dict, self, name).CombinedOutput(); err != nil {
+			t.Skipf("objcopy failed: %v\n%s", err, out)
+		}
+		if err := os.Chmod(name, 0777); err != nil {
+			t.Fatal(err)
+		}
+		run(t, name, ".marisa")
+	})
+}
@@ -0,0 +1,74 @@
+package marisa
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/pgaskin/go-marisa/internal/layout"
+)
+
+// Info contains information about a serialized dictionary. See [Inspect].
+type Info struct {
+	Stats
+
+	// CacheLevel is inferred from the size of the cache (see [Trie.Config]).
+	// It is zero if it couldn't be determined.
+	CacheLevel CacheLevel
+}
+
+// Config returns the configuration of the dictionary.
+func (i Info) Config() Config {
+	return Config{
+		NumTries:   int(i.NumTries),
+		CacheLevel: i.CacheLevel,
+		TailMode:   i.TailMode,
+		NodeOrder:  i.NodeOrder,
+	}
+}
+
+// Inspect returns information about the little-endian dictionary in r without
+// loading it. Only the header and the fixed-size fields of each section are
+// read, so this is much faster than loading the dictionary, and only a tiny
+// fraction of it needs to be read. If r has a Size method (e.g.,
+// [*io.SectionReader] or [*bytes.Reader]), it is used to check that the
+// dictionary isn't truncated.
+//
+// MARISA doesn't store a format version. The header magic identifies the only
+// format, which is used by all versions of MARISA since 0.2.0.
+//
+// If the header or section sizes are malformed, an error matching [ErrCorrupt]
+// is returned. Since the data isn't read, this doesn't check whether the
+// dictionary is well-formed (see [Validate]).
+func Inspect(r io.ReaderAt) (Info, error) {
+	size, known := int64(maxAlloc), false
+	if s, ok := r.(interface{ Size() int64 }); ok {
+		size, known = min(s.Size(), size), true
+	}
+	l, err := layout.Parse(r, size, binary.LittleEndian, 8)
+	if err != nil {
+		if !known && errors.Is(err, io.ErrUnexpectedEOF) {
+			return Info{}, corrupt(-1, "truncated", err)
+		}
+		return Info{}, corruptLayoutError(err, size)
+	}
+	return layoutInfo(l), nil
+}
+
+// layoutInfo returns information about the dictionary from its layout. This
+// must match marisa::Trie.
+func layoutInfo(l *layout.Trie) Info {
+	flags := configFlag(l.Flags)
+	return Info{
+		Stats: Stats{
+			Size:      l.NumKeys(),
+			DiskSize:  uint32(l.IOSize()),
+			TotalSize: uint32(l.TotalSize()),
+			NumTries:  uint32(flagNumTries(flags)),
+			NumNodes:  l.NumNodes(),
+			TailMode:  flagTailMode(flags & _MARISA_TAIL_MODE_MASK),
+			NodeOrder: flagNodeOrder(flags),
+		},
+		CacheLevel: inferCacheLevel(l.NumKeys(), l.Cache.Len()),
+	}
+}
@@ -0,0 +1,83 @@
+package marisa_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/pgaskin/go-marisa"
+	"github.com/pgaskin/go-marisa/testdata"
+)
+
+func TestInspect(t *testing.T) {
+	for _, tc := range []struct {
+		Name string
+		Keys []string
+		Cfg  marisa.Config
+	}{
+		{"Words", testdata.Words, marisa.Config{}},
+		{"Go125", testdata.Go125, marisa.Config{NumTries: 5, TailMode: marisa.BinaryTail, NodeOrder: marisa.LabelOrder}},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			var trie marisa.Trie
+			if err := trie.Build(slices.Values(tc.Keys), tc.Cfg); err != nil {
+				t.Fatalf("error: %v", err)
+			}
+			defer trie.Close()
+
+			buf, err := trie.MarshalBinary()
+			if err != nil {
+				t.Fatalf("error: %v", err)
+			}
+
+			r := &countingReaderAt{R: bytes.NewReader(buf)}
+			info, err := marisa.Inspect(r)
+			if err != nil {
+				t.Fatalf("error: %v", err)
+			}
+			if exp := trie.Stats(); info.Stats != exp {
+				t.Errorf("incorrect stats:\nact: %+v\nexp: %+v", info.Stats, exp)
+			}
+			if exp := trie.Config(); info.Config() != exp {
+				t.Errorf("incorrect config: expected %v, got %v", exp, info.Config())
+			}
+			if r.N > 4096 {
+				t.Errorf("read too much (%d of %d bytes)", r.N, len(buf))
+			}
+
+			// without a Size method
+			if act, err := marisa.Inspect(struct{ io.ReaderAt }{bytes.NewReader(buf)}); err != nil {
+				t.Errorf("error: %v", err)
+			} else if act != info {
+				t.Errorf("incorrect info without size: %+v", act)
+			}
+		})
+	}
+	t.Run("Corrupt", func(t *testing.T) {
+		buf := mustWordsTrieData()
+		for _, r := range []io.ReaderAt{
+			bytes.NewReader(nil),
+			bytes.NewReader([]byte("We love Marisa?\x00")),
+			bytes.NewReader(buf[:len(buf)/2]),
+			struct{ io.ReaderAt }{bytes.NewReader(buf[:len(buf)/2])},
+		} {
+			if _, err := marisa.Inspect(r); !errors.Is(err, marisa.ErrCorrupt) {
+				t.Errorf("expected corrupt error, got %v", err)
+			}
+		}
+	})
+}
+
+// countingReaderAt counts the number of bytes read.
+type countingReaderAt struct {
+	R io.ReaderAt
+	N int
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.R.ReadAt(p, off)
+	c.N += n
+	return n, err
+}
@@ -1,3 +1,5 @@
 package internal
 
 var NoCacheQuery bool
+
+var NoVirtualMemory bool
@@ -0,0 +1,261 @@
+package layout
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// Check checks the consistency of the little-endian dictionary b, which must
+// have been parsed as t. This includes the bit vector rank/select indexes and
+// the relationships between the sizes of each component. If it is malformed, an
+// [*Error] is returned.
+func Check(b []byte, t *Trie) error {
+	for level := 0; t != nil; level, t = level+1, t.Next {
+		pfx := fmt.Sprintf("trie[%d].", level)
+		for _, x := range []struct {
+			name    string
+			bv      BitVector
+			indexed bool
+		}{
+			{"louds", t.Louds, true},
+			{"terminal_flags", t.TerminalFlags, level == 0},
+			{"link_flags", t.LinkFlags, true},
+			{"tail.end_flags", t.Tail.EndFlags, false},
+		} {
+			if err := checkBitVector(b, pfx+x.name, x.bv, x.indexed); err != nil {
+				return err
+			}
+		}
+		if t.Louds.Size < 4 || t.Louds.Size%2 != 0 || t.Louds.NumOnes != t.NumNodes() {
+			return &Error{t.Louds.Units.Offset, pfx + "louds", "invalid number of nodes"}
+		}
+		if level == 0 && t.TerminalFlags.Size != t.NumNodes()+1 {
+			return &Error{t.TerminalFlags.Units.Offset, pfx + "terminal_flags", "inconsistent with the number of nodes"}
+		}
+		if level != 0 && t.TerminalFlags.Size != 0 {
+			return &Error{t.TerminalFlags.Units.Offset, pfx + "terminal_flags", "unexpected terminal flags in nested trie"}
+		}
+		if t.LinkFlags.Size != t.NumNodes() {
+			return &Error{t.LinkFlags.Units.Offset, pfx + "link_flags", "inconsistent with the number of nodes"}
+		}
+		if t.Bases.Len() != uint64(t.NumNodes()) {
+			return &Error{t.Bases.Offset, pfx + "bases", "inconsistent with the number of nodes"}
+		}
+		if t.Extras.Size != uint64(t.LinkFlags.NumOnes) {
+			return &Error{t.Extras.Units.Offset, pfx + "extras", "inconsistent with the number of links"}
+		}
+		if t.Tail.EndFlags.Size != 0 && uint64(t.Tail.EndFlags.Size) != t.Tail.Buf.Size {
+			return &Error{t.Tail.EndFlags.Units.Offset, pfx + "tail.end_flags", "inconsistent with the tail size"}
+		}
+		if n := t.Cache.Len(); n == 0 || n&(n-1) != 0 {
+			return &Error{t.Cache.Offset, pfx + "cache", "size is not a power of two"}
+		}
+		if err := checkCache(b, pfx+"cache", t); err != nil {
+			return err
+		}
+		if err := checkLinks(b, pfx+"extras", t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkBitVector ensures the bit vector has no stray bits past the end, and
+// that its popcount and rank/select indexes (if indexed) match what marisa
+// would build.
+func checkBitVector(b []byte, field string, bv BitVector, indexed bool) error {
+	units := b[bv.Units.Data() : bv.Units.Data()+int64(bv.Units.Size)]
+	if uint64(len(units))*8-uint64(bv.Size) >= 64 {
+		return &Error{bv.Units.Offset, field + ".units", "too many units"}
+	}
+	if !indexed && bv.Ranks.Size+bv.Select0s.Size+bv.Select1s.Size != 0 {
+		return &Error{bv.Ranks.Offset, field + ".ranks", "unexpected index"}
+	}
+
+	// note: we use 64-bit units regardless of the dictionary unit size since
+	// the result is the same for little-endian dictionaries
+
+	var (
+		n        = uint64(bv.Size)
+		num1s    uint64
+		num0s    uint64
+		ranks    []uint32 // abs, rel_lo, rel_hi
+		select0s []uint32
+		select1s []uint32
+	)
+	nr := n/512 + 1
+	if n%512 != 0 {
+		nr++
+	}
+	ranks = make([]uint32, nr*3)
+	for bit := uint64(0); bit < n; bit += 64 {
+		if r := ranks[bit/512*3:][:3]; bit%512 == 0 {
+			r[0] = uint32(num1s)
+		} else {
+			setRel(r, int(bit/64%8), num1s-uint64(r[0]))
+		}
+
+		var w uint64
+		if i := int(bit / 8); i+8 <= len(units) {
+			w = binary.LittleEndian.Uint64(units[i:])
+		} else {
+			var tmp [8]byte
+			copy(tmp[:], units[i:])
+			w = binary.LittleEndian.Uint64(tmp[:])
+		}
+		nb := min(n-bit, 64)
+		if nb < 64 && w>>nb != 0 {
+			return &Error{bv.Units.Offset, field + ".units", "bits set past the end"}
+		}
+
+		u1 := uint64(bits.OnesCount64(w))
+		u0 := nb - u1
+		if k := (512 - num0s%512) % 512; k < u0 {
+			select0s = append(select0s, uint32(bit+selectBit(^w, k)))
+		}
+		if k := (512 - num1s%512) % 512; k < u1 {
+			select1s = append(select1s, uint32(bit+selectBit(w, k)))
+		}
+		num0s += u0
+		num1s += u1
+	}
+	if n%512 != 0 {
+		r := ranks[(n-1)/512*3:][:3]
+		for k := int((n-1)/64%8) + 1; k < 8; k++ {
+			setRel(r, k, num1s-uint64(r[0]))
+		}
+	}
+	ranks[len(ranks)-3] = uint32(num1s)
+	select0s = append(select0s, uint32(n))
+	select1s = append(select1s, uint32(n))
+
+	if num1s != uint64(bv.NumOnes) {
+		return &Error{bv.Units.Offset, field + ".num_1s", "does not match the units"}
+	}
+	if !indexed {
+		return nil
+	}
+	if !equalU32(b, bv.Ranks, ranks) {
+		return &Error{bv.Ranks.Offset, field + ".ranks", "does not match the units"}
+	}
+	if bv.Select0s.Size != 0 && !equalU32(b, bv.Select0s, select0s) {
+		return &Error{bv.Select0s.Offset, field + ".select0s", "does not match the units"}
+	}
+	if bv.Select1s.Size != 0 && !equalU32(b, bv.Select1s, select1s) {
+		return &Error{bv.Select1s.Offset, field + ".select1s", "does not match the units"}
+	}
+	return nil
+}
+
+// checkCache ensures the cache only references valid nodes and links. Entries
+// with an invalid parent are unused (they never match a node).
+func checkCache(b []byte, field string, t *Trie) error {
+	limit := linkLimit(t)
+	d := b[t.Cache.Data():][:t.Cache.Size]
+	for i := 0; i < len(d); i += 12 {
+		parent := binary.LittleEndian.Uint32(d[i:])
+		child := binary.LittleEndian.Uint32(d[i+4:])
+		link := binary.LittleEndian.Uint32(d[i+8:])
+		if parent >= t.NumNodes() {
+			continue
+		}
+		if child >= t.NumNodes() {
+			return &Error{t.Cache.Data() + int64(i), field, "node out of range"}
+		}
+		if link>>8 != invalidExtra && uint64(link) >= limit {
+			return &Error{t.Cache.Data() + int64(i), field, "link out of range"}
+		}
+	}
+	return nil
+}
+
+// invalidExtra is MARISA_INVALID_EXTRA.
+const invalidExtra = math.MaxUint32 >> 8
+
+// linkLimit returns the upper bound of links in t.
+func linkLimit(t *Trie) uint64 {
+	if t.Next != nil {
+		return uint64(t.Next.NumNodes())
+	}
+	return t.Tail.Buf.Size
+}
+
+// checkLinks ensures each link references a valid node in the next trie or a
+// valid offset in the tail.
+func checkLinks(b []byte, field string, t *Trie) error {
+	var (
+		flags  = b[t.LinkFlags.Units.Data():][:t.LinkFlags.Units.Size]
+		bases  = b[t.Bases.Data():][:t.Bases.Size]
+		extras = b[t.Extras.Units.Data():][:t.Extras.Units.Size]
+		limit  = linkLimit(t)
+		link   uint64
+	)
+	for node := uint64(0); node < uint64(t.LinkFlags.Size); node++ {
+		if flags[node/8]>>(node%8)&1 == 0 {
+			continue
+		}
+		x := uint64(bases[node]) | flatVectorGet(extras, t.Extras.ValueSize, t.Extras.Mask, link)<<8
+		if x >= limit {
+			return &Error{t.Extras.Units.Data(), field, "link out of range"}
+		}
+		link++
+	}
+	return nil
+}
+
+// flatVectorGet gets the i-th value in a flat vector with the specified units.
+func flatVectorGet(units []byte, valueSize, mask uint32, i uint64) uint64 {
+	pos := i * uint64(valueSize)
+	var w [16]byte
+	copy(w[:], units[min(pos/64*8, uint64(len(units))):])
+	lo := binary.LittleEndian.Uint64(w[:])
+	hi := binary.LittleEndian.Uint64(w[8:])
+	v := lo >> (pos % 64)
+	if pos%64 != 0 {
+		v |= hi << (64 - pos%64)
+	}
+	return v & uint64(mask)
+}
+
+// setRel sets the relative rank r (1-7) in the rank index x.
+func setRel(x []uint32, r int, v uint64) {
+	switch r {
+	case 1:
+		x[1] = x[1]&^0x7F | uint32(v)&0x7F
+	case 2:
+		x[1] = x[1]&^(0xFF<<7) | (uint32(v)&0xFF)<<7
+	case 3:
+		x[1] = x[1]&^(0xFF<<15) | (uint32(v)&0xFF)<<15
+	case 4:
+		x[1] = x[1]&^(0x1FF<<23) | (uint32(v)&0x1FF)<<23
+	case 5:
+		x[2] = x[2]&^0x1FF | uint32(v)&0x1FF
+	case 6:
+		x[2] = x[2]&^(0x1FF<<9) | (uint32(v)&0x1FF)<<9
+	case 7:
+		x[2] = x[2]&^(0x1FF<<18) | (uint32(v)&0x1FF)<<18
+	}
+}
+
+// selectBit returns the position of the k-th (0-indexed) set bit in w.
+func selectBit(w uint64, k uint64) uint64 {
+	for range k {
+		w &= w - 1
+	}
+	return uint64(bits.TrailingZeros64(w))
+}
+
+func equalU32(b []byte, v Vector, x []uint32) bool {
+	if v.Size != uint64(len(x))*4 {
+		return false
+	}
+	d := b[v.Data():]
+	for i, y := range x {
+		if binary.LittleEndian.Uint32(d[i*4:]) != y {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,379 @@
+// Package layout parses the serialized layout of MARISA dictionaries without
+// loading them.
+package layout
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Header is the magic at the start of every serialized dictionary.
+const Header = "We love Marisa.\x00"
+
+// MaxNumTries is the maximum nesting depth of tries.
+const MaxNumTries = 0x7F
+
+// Error is returned for malformed dictionaries.
+type Error struct {
+	Offset int64  // offset of the field
+	Field  string // name of the field
+	Reason string // what is wrong with it
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("invalid dictionary: %s at offset %d: %s", e.Field, e.Offset, e.Reason)
+}
+
+// Vector is a serialized marisa::grimoire::vector::Vector.
+type Vector struct {
+	Offset   int64  // offset of the size field
+	Size     uint64 // total size of the data (without padding)
+	ElemSize int    // size of each element
+	Width    int    // size of each scalar in an element, for byte-swapping
+}
+
+// Data returns the offset of the vector data.
+func (v Vector) Data() int64 {
+	return v.Offset + 8
+}
+
+// Len returns the number of elements.
+func (v Vector) Len() uint64 {
+	return v.Size / uint64(v.ElemSize)
+}
+
+// IOSize returns the serialized size of the vector, including padding.
+func (v Vector) IOSize() int64 {
+	return 8 + int64(pad8(v.Size))
+}
+
+// BitVector is a serialized marisa::grimoire::vector::BitVector.
+type BitVector struct {
+	Units    Vector
+	Size     uint32 // number of bits
+	NumOnes  uint32
+	Ranks    Vector
+	Select0s Vector
+	Select1s Vector
+}
+
+// TotalSize returns the in-memory size of the bit vector.
+func (b BitVector) TotalSize() uint64 {
+	return b.Units.Size + b.Ranks.Size + b.Select0s.Size + b.Select1s.Size
+}
+
+// IOSize returns the serialized size of the bit vector.
+func (b BitVector) IOSize() int64 {
+	return b.Units.IOSize() + 8 + b.Ranks.IOSize() + b.Select0s.IOSize() + b.Select1s.IOSize()
+}
+
+// FlatVector is a serialized marisa::grimoire::vector::FlatVector.
+type FlatVector struct {
+	Units     Vector
+	ValueSize uint32
+	Mask      uint32
+	Size      uint64 // number of values
+}
+
+// TotalSize returns the in-memory size of the flat vector.
+func (f FlatVector) TotalSize() uint64 {
+	return f.Units.Size
+}
+
+// IOSize returns the serialized size of the flat vector.
+func (f FlatVector) IOSize() int64 {
+	return f.Units.IOSize() + 16
+}
+
+// Tail is a serialized marisa::grimoire::trie::Tail.
+type Tail struct {
+	Buf      Vector
+	EndFlags BitVector
+}
+
+// TotalSize returns the in-memory size of the tail.
+func (t Tail) TotalSize() uint64 {
+	return t.Buf.Size + t.EndFlags.TotalSize()
+}
+
+// IOSize returns the serialized size of the tail.
+func (t Tail) IOSize() int64 {
+	return t.Buf.IOSize() + t.EndFlags.IOSize()
+}
+
+// Trie is a serialized marisa::grimoire::trie::LoudsTrie, excluding the header.
+type Trie struct {
+	Louds         BitVector
+	TerminalFlags BitVector
+	LinkFlags     BitVector
+	Bases         Vector
+	Extras        FlatVector
+	Tail          Tail
+	Next          *Trie // nil if the last trie
+	Cache         Vector
+	NumL1Nodes    uint32
+	Flags         uint32 // config flags
+}
+
+// NumTries returns the number of nested tries, including t.
+func (t *Trie) NumTries() int {
+	var n int
+	for ; t != nil; t = t.Next {
+		n++
+	}
+	return n
+}
+
+// NumKeys returns the number of keys in the trie.
+func (t *Trie) NumKeys() uint32 {
+	return t.TerminalFlags.NumOnes
+}
+
+// NumNodes returns the number of nodes in the trie.
+func (t *Trie) NumNodes() uint32 {
+	return t.Louds.Size/2 - 1
+}
+
+// TotalSize returns the in-memory size of the trie, including nested tries.
+func (t *Trie) TotalSize() uint64 {
+	var n uint64
+	for ; t != nil; t = t.Next {
+		n += t.Louds.TotalSize()
+		n += t.TerminalFlags.TotalSize()
+		n += t.LinkFlags.TotalSize()
+		n += t.Bases.Size
+		n += t.Extras.TotalSize()
+		n += t.Tail.TotalSize()
+		n += t.Cache.Size
+	}
+	return n
+}
+
+// IOSize returns the serialized size of the trie, including the header and
+// nested tries.
+func (t *Trie) IOSize() int64 {
+	n := int64(len(Header))
+	for ; t != nil; t = t.Next {
+		n += t.Louds.IOSize()
+		n += t.TerminalFlags.IOSize()
+		n += t.LinkFlags.IOSize()
+		n += t.Bases.IOSize()
+		n += t.Extras.IOSize()
+		n += t.Tail.IOSize()
+		n += t.Cache.IOSize()
+		n += 8
+	}
+	return n
+}
+
+// Parse parses the layout of the dictionary in r, which must be size bytes
+// long. Only the header and fixed-size fields are read. The unit size is the
+// size of a bit vector unit (i.e., the word size of the platform which wrote the
+// dictionary), and only matters for byte-swapping.
+//
+// If r ends before the dictionary does, [io.ErrUnexpectedEOF] is returned. If
+// the dictionary is malformed, an [*Error] is returned.
+func Parse(r io.ReaderAt, size int64, order binary.ByteOrder, unit int) (*Trie, error) {
+	if unit != 4 && unit != 8 {
+		return nil, errors.New("invalid unit size")
+	}
+	p := &parser{r: r, size: size, order: order, unit: unit}
+
+	var hdr [len(Header)]byte
+	if err := p.read(hdr[:]); err != nil {
+		return nil, err
+	}
+	if string(hdr[:]) != Header {
+		return nil, &Error{0, "header", "bad magic"}
+	}
+	return p.trie(1)
+}
+
+type parser struct {
+	r     io.ReaderAt
+	off   int64
+	size  int64
+	order binary.ByteOrder
+	unit  int
+}
+
+func (p *parser) read(b []byte) error {
+	if p.size-p.off < int64(len(b)) {
+		return io.ErrUnexpectedEOF
+	}
+	if n, err := p.r.ReadAt(b, p.off); n != len(b) {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	p.off += int64(len(b))
+	return nil
+}
+
+func (p *parser) u32() (uint32, error) {
+	var b [4]byte
+	if err := p.read(b[:]); err != nil {
+		return 0, err
+	}
+	return p.order.Uint32(b[:]), nil
+}
+
+func (p *parser) u64() (uint64, error) {
+	var b [8]byte
+	if err := p.read(b[:]); err != nil {
+		return 0, err
+	}
+	return p.order.Uint64(b[:]), nil
+}
+
+func (p *parser) fail(off int64, field, reason string) error {
+	return &Error{off, field, reason}
+}
+
+func (p *parser) vector(field string, elem, width int) (Vector, error) {
+	v := Vector{Offset: p.off, ElemSize: elem, Width: width}
+	n, err := p.u64()
+	if err != nil {
+		return v, err
+	}
+	if n > math.MaxUint32 {
+		return v, p.fail(v.Offset, field, "size out of range")
+	}
+	if n%uint64(elem) != 0 {
+		return v, p.fail(v.Offset, field, "size is not a multiple of the element size")
+	}
+	if uint64(p.size-p.off) < pad8(n) {
+		return v, io.ErrUnexpectedEOF
+	}
+	v.Size = n
+	p.off += int64(pad8(n))
+	return v, nil
+}
+
+func (p *parser) bitVector(field string) (BitVector, error) {
+	var (
+		b   BitVector
+		err error
+	)
+	if b.Units, err = p.vector(field+".units", p.unit, p.unit); err != nil {
+		return b, err
+	}
+	off := p.off
+	if b.Size, err = p.u32(); err != nil {
+		return b, err
+	}
+	if uint64(b.Size) > b.Units.Size*8 {
+		return b, p.fail(off, field+".size", "larger than the units")
+	}
+	off = p.off
+	if b.NumOnes, err = p.u32(); err != nil {
+		return b, err
+	}
+	if b.NumOnes > b.Size {
+		return b, p.fail(off, field+".num_1s", "larger than the size")
+	}
+	if b.Ranks, err = p.vector(field+".ranks", 12, 4); err != nil {
+		return b, err
+	}
+	if b.Select0s, err = p.vector(field+".select0s", 4, 4); err != nil {
+		return b, err
+	}
+	if b.Select1s, err = p.vector(field+".select1s", 4, 4); err != nil {
+		return b, err
+	}
+	return b, nil
+}
+
+func (p *parser) flatVector(field string) (FlatVector, error) {
+	var (
+		f   FlatVector
+		err error
+	)
+	if f.Units, err = p.vector(field+".units", p.unit, p.unit); err != nil {
+		return f, err
+	}
+	off := p.off
+	if f.ValueSize, err = p.u32(); err != nil {
+		return f, err
+	}
+	if f.ValueSize > 32 {
+		return f, p.fail(off, field+".value_size", "out of range")
+	}
+	if f.Mask, err = p.u32(); err != nil {
+		return f, err
+	}
+	off = p.off
+	if f.Size, err = p.u64(); err != nil {
+		return f, err
+	}
+	if f.Size*uint64(f.ValueSize) > f.Units.Size*8 {
+		return f, p.fail(off, field+".size", "larger than the units")
+	}
+	return f, nil
+}
+
+func (p *parser) tail(field string) (Tail, error) {
+	var (
+		t   Tail
+		err error
+	)
+	if t.Buf, err = p.vector(field+".buf", 1, 1); err != nil {
+		return t, err
+	}
+	if t.EndFlags, err = p.bitVector(field + ".end_flags"); err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+func (p *parser) trie(level int) (*Trie, error) {
+	var (
+		t   Trie
+		err error
+		pfx = fmt.Sprintf("trie[%d].", level-1)
+	)
+	if t.Louds, err = p.bitVector(pfx + "louds"); err != nil {
+		return nil, err
+	}
+	if t.TerminalFlags, err = p.bitVector(pfx + "terminal_flags"); err != nil {
+		return nil, err
+	}
+	if t.LinkFlags, err = p.bitVector(pfx + "link_flags"); err != nil {
+		return nil, err
+	}
+	if t.Bases, err = p.vector(pfx+"bases", 1, 1); err != nil {
+		return nil, err
+	}
+	if t.Extras, err = p.flatVector(pfx + "extras"); err != nil {
+		return nil, err
+	}
+	if t.Tail, err = p.tail(pfx + "tail"); err != nil {
+		return nil, err
+	}
+	if t.LinkFlags.NumOnes != 0 && t.Tail.Buf.Size == 0 {
+		if level >= MaxNumTries {
+			return nil, p.fail(p.off, pfx+"next", "too many tries")
+		}
+		if t.Next, err = p.trie(level + 1); err != nil {
+			return nil, err
+		}
+	}
+	if t.Cache, err = p.vector(pfx+"cache", 12, 4); err != nil {
+		return nil, err
+	}
+	if t.NumL1Nodes, err = p.u32(); err != nil {
+		return nil, err
+	}
+	if t.Flags, err = p.u32(); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func pad8(n uint64) uint64 {
+	return (n + 7) &^ 7
+}
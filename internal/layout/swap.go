@@ -0,0 +1,62 @@
+package layout
+
+import (
+	"math/bits"
+)
+
+// Swap reverses the byte order of every scalar in the dictionary b, which must
+// have been parsed as t. It panics if b is too short.
+func Swap(b []byte, t *Trie) {
+	for ; t != nil; t = t.Next {
+		swapBitVector(b, t.Louds)
+		swapBitVector(b, t.TerminalFlags)
+		swapBitVector(b, t.LinkFlags)
+		swapVector(b, t.Bases)
+		swapFlatVector(b, t.Extras)
+		swapVector(b, t.Tail.Buf)
+		swapBitVector(b, t.Tail.EndFlags)
+		swapVector(b, t.Cache)
+		off := t.Cache.Offset + t.Cache.IOSize()
+		swapN(b[off:off+8], 4) // num_l1_nodes, config
+	}
+}
+
+func swapVector(b []byte, v Vector) {
+	swapN(b[v.Offset:v.Offset+8], 8)
+	swapN(b[v.Data():v.Data()+int64(v.Size)], v.Width)
+}
+
+func swapBitVector(b []byte, v BitVector) {
+	swapVector(b, v.Units)
+	off := v.Units.Offset + v.Units.IOSize()
+	swapN(b[off:off+8], 4) // size, num_1s
+	swapVector(b, v.Ranks)
+	swapVector(b, v.Select0s)
+	swapVector(b, v.Select1s)
+}
+
+func swapFlatVector(b []byte, v FlatVector) {
+	swapVector(b, v.Units)
+	off := v.Units.Offset + v.Units.IOSize()
+	swapN(b[off:off+8], 4)    // value_size, mask
+	swapN(b[off+8:off+16], 8) // size
+}
+
+// swapN reverses the byte order of each n-byte scalar in b.
+func swapN(b []byte, n int) {
+	switch n {
+	case 1:
+	case 4:
+		for i := 0; i+4 <= len(b); i += 4 {
+			x := uint32(b[i]) | uint32(b[i+1])<<8 | uint32(b[i+2])<<16 | uint32(b[i+3])<<24
+			x = bits.ReverseBytes32(x)
+			b[i], b[i+1], b[i+2], b[i+3] = byte(x), byte(x>>8), byte(x>>16), byte(x>>24)
+		}
+	case 8:
+		for i := 0; i+8 <= len(b); i += 8 {
+			b[i], b[i+1], b[i+2], b[i+3], b[i+4], b[i+5], b[i+6], b[i+7] = b[i+7], b[i+6], b[i+5], b[i+4], b[i+3], b[i+2], b[i+1], b[i]
+		}
+	default:
+		panic("layout: invalid scalar width")
+	}
+}
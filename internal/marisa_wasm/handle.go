@@ -0,0 +1,176 @@
+package marisa_wasm
+
+// note: this file is not generated, it is kept alongside marisa.go since it
+// needs access to the module memory and internal functions
+
+// note: marisa.go was last generated from a version of src/wrapper.cc with a
+// single static marisa::Trie used by all exports, so this implements the
+// handle-based exports on top of it; this file must be deleted when the module
+// is regenerated (which will fail to compile until it is)
+//
+// A marisa::Trie is only a pointer to the LoudsTrie, so a handle is the same
+// pointer allocated on the heap, and each export swaps it into the static trie
+// for the duration of the call, which is exactly what the handle-based exports
+// would do with the same code.
+
+// staticTrie is the address of the static marisa::Trie.
+const staticTrie = 12816
+
+// useTrie swaps the trie handle h into the static trie, returning a function to
+// swap it back (and update the handle if the trie was replaced).
+func (m *Module) useTrie(h int32) func() {
+	old := load32((*m.memory)[staticTrie:])
+	store32((*m.memory)[staticTrie:], load32((*m.memory)[uint32(h):]))
+	return func() {
+		store32((*m.memory)[uint32(h):], load32((*m.memory)[staticTrie:]))
+		store32((*m.memory)[staticTrie:], old)
+	}
+}
+
+// XTrieNew implements TrieNew.
+func (m *Module) XTrieNew() int32 {
+	h := m._operator_new_unsigned_long__tz8azg(4)
+	store32((*m.memory)[uint32(h):], 0)
+	return h
+}
+
+// XTrieFree implements TrieFree.
+func (m *Module) XTrieFree(v0 int32) {
+	if v0 != 0 {
+		if p := int32(load32((*m.memory)[uint32(v0):])); p != 0 {
+			m.Xfree(m._marisa__grimoire__trie__LoudsTrie___LoudsTrie___9dxe8l(p))
+		}
+		m.Xfree(v0)
+	}
+}
+
+// XTrieMap implements TrieMap.
+func (m *Module) XTrieMap(v0, v1, v2 int32) {
+	defer m.useTrie(v0)()
+
+	// Trie::map is inlined into XNew, which keeps the new LoudsTrie in a
+	// unique_ptr at the top of its stack frame, and maps into a temporary
+	// LoudsTrie on the stack below it (which owns the nested tries); if it
+	// throws, free them and move the new one into the trie like
+	// Trie::map_in_place would have
+	frame := uint32(m.___stack_pointer - 16)
+	temp, stack := frame+12, frame-560
+	store32((*m.memory)[temp:], 0)
+	clear((*m.memory)[stack:frame]) // so it's safe to destroy if it wasn't constructed
+	var ok bool
+	defer func() {
+		if !ok {
+			m._marisa__grimoire__trie__LoudsTrie___LoudsTrie___9dxe8l(int32(stack))
+			if p := load32((*m.memory)[temp:]); p != 0 {
+				if old := int32(load32((*m.memory)[staticTrie:])); old != 0 {
+					m.Xfree(m._marisa__grimoire__trie__LoudsTrie___LoudsTrie___9dxe8l(old))
+				}
+				store32((*m.memory)[staticTrie:], p)
+			}
+		}
+	}()
+	m.XNew(v1, v2)
+	ok = true
+}
+
+// XTrieLoad implements TrieLoad.
+func (m *Module) XTrieLoad(v0 int32) {
+	defer m.useTrie(v0)()
+	m.XLoad()
+}
+
+// XTrieSave implements TrieSave.
+func (m *Module) XTrieSave(v0 int32) {
+	defer m.useTrie(v0)()
+	m.XSave()
+}
+
+// XTrieBuild implements TrieBuild.
+func (m *Module) XTrieBuild(v0, v1 int32) {
+	defer m.useTrie(v0)()
+	m.XBuild(v1)
+}
+
+// XTrieStat implements TrieStat.
+func (m *Module) XTrieStat(v0 int32) (int32, int32, int32, int32, int32, int32, int32) {
+	defer m.useTrie(v0)()
+	return m.XStat()
+}
+
+// XTrieLookup implements TrieLookup.
+func (m *Module) XTrieLookup(v0, v1 int32) int32 {
+	defer m.useTrie(v0)()
+	return m.XQueryLookup(v1)
+}
+
+// XTrieReverseLookup implements TrieReverseLookup.
+func (m *Module) XTrieReverseLookup(v0, v1 int32) int32 {
+	defer m.useTrie(v0)()
+	return m.XQueryReverseLookup(v1)
+}
+
+// XTrieCommonPrefixSearch implements TrieCommonPrefixSearch.
+func (m *Module) XTrieCommonPrefixSearch(v0, v1 int32) int32 {
+	defer m.useTrie(v0)()
+	return m.XQueryCommonPrefixSearch(v1)
+}
+
+// XTriePredictiveSearch implements TriePredictiveSearch.
+func (m *Module) XTriePredictiveSearch(v0, v1 int32) int32 {
+	defer m.useTrie(v0)()
+	return m.XQueryPredictiveSearch(v1)
+}
+
+// XTrieLevel implements TrieLevel.
+func (m *Module) XTrieLevel(v0, v1 int32) (int32, int32, int32, int32, int32, int32, int32) {
+	// offsets of marisa::grimoire::trie::LoudsTrie members, from total_size
+	const (
+		loudsTerminalFlags = 104
+		loudsLinkFlags     = 208
+		loudsBases         = 312
+		loudsExtras        = 336
+		loudsTail          = 372
+		loudsNextTrie      = 500
+		loudsCache         = 504
+	)
+	p := load32((*m.memory)[uint32(v0):])
+	if p == 0 {
+		m.throwLogicError(8348, 8392, "trie_ == nullptr")
+	}
+	for ; v1 != 0; v1-- {
+		if p = load32((*m.memory)[p+loudsNextTrie:]); p == 0 {
+			m.throwLogicError(8476, 8488, "level >= num_tries()") // std::out_of_range
+		}
+	}
+	return int32(m.bitVectorSize(p)),
+		int32(m.bitVectorSize(p + loudsTerminalFlags)),
+		int32(m.bitVectorSize(p + loudsLinkFlags)),
+		int32(m.vectorSize(p+loudsBases, 1)),
+		int32(m.vectorSize(p+loudsExtras, 4)),
+		int32(m.vectorSize(p+loudsTail, 1) + m.bitVectorSize(p+loudsTail+24)),
+		int32(m.vectorSize(p+loudsCache, 12))
+}
+
+// vectorSize returns the total_size of the marisa::grimoire::vector::Vector at
+// p with elements of size n.
+func (m *Module) vectorSize(p, n uint32) uint32 {
+	return load32((*m.memory)[p+12:]) * n
+}
+
+// bitVectorSize returns the total_size of the
+// marisa::grimoire::vector::BitVector at p.
+func (m *Module) bitVectorSize(p uint32) uint32 {
+	return m.vectorSize(p, 4) + m.vectorSize(p+32, 12) + m.vectorSize(p+56, 4) + m.vectorSize(p+80, 4)
+}
+
+// throwLogicError throws a std::logic_error (or a subclass with the specified
+// vtable and typeinfo) with the specified message.
+func (m *Module) throwLogicError(vtable, typeinfo int32, what string) {
+	s := m.___stack_pointer - int32(len(what)+1+15)&^15
+	m.___stack_pointer = s // restored by the caller after catching the error
+	(*m.memory)[uint32(s)+uint32(copy((*m.memory)[uint32(s):], what))] = 0
+	e := m._std__logic_error__logic_error_char_const___w3rrgk(m.___cxa_allocate_exception(8), s)
+	store32((*m.memory)[uint32(e):], uint32(vtable))
+	m.___cxa_throw(e, typeinfo, 1)
+	panic("unreachable")
+}
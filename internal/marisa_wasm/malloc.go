@@ -0,0 +1,68 @@
+package marisa_wasm
+
+// note: this file is not generated, it is kept alongside marisa.go since it
+// needs access to the module memory
+
+// note: wasi-libc builds dlmalloc with NO_MALLINFO, so this implements the
+// equivalent of internal_mallinfo by reading the allocator state directly; the
+// addresses must be updated when the module is regenerated (MallocStats will
+// return false until they are)
+
+const (
+	mallocState  = 13260 // _gm_ (struct malloc_state)
+	mallocParams = 13732 // mparams (struct malloc_params)
+)
+
+// MallocStats returns the number of bytes allocated and free in the heap, like
+// the uordblks and fordblks fields returned by mallinfo. If the allocator state
+// isn't initialized or is inconsistent, ok is false.
+func (m *Module) MallocStats() (inUse, free uint32, ok bool) {
+	const (
+		topsize       = 12
+		top           = 24
+		magic         = 36
+		footprint     = 432
+		seg           = 448
+		topFootSize   = 56
+		fencepostHead = 7
+	)
+	mem := *m.memory
+	if len(mem) < mallocParams+4 {
+		return 0, 0, false
+	}
+	if x := load32(mem[mallocState+magic:]); x == 0 || x != load32(mem[mallocParams:]) {
+		return 0, 0, false
+	}
+	var (
+		t     = load32(mem[mallocState+top:])
+		mfree = load32(mem[mallocState+topsize:]) + topFootSize
+	)
+	for s := uint32(mallocState + seg); s != 0; s = load32(mem[s+8:]) {
+		if uint64(s)+12 > uint64(len(mem)) {
+			return 0, 0, false
+		}
+		base, size := load32(mem[s:]), load32(mem[s+4:])
+		for q := base + -(base+8)&15; q >= base && q-base < size && q != t; {
+			if uint64(q)+8 > uint64(len(mem)) {
+				return 0, 0, false
+			}
+			head := load32(mem[q+4:])
+			if head == fencepostHead {
+				break
+			}
+			sz := head &^ 7
+			if sz == 0 {
+				return 0, 0, false
+			}
+			if head&3 == 1 {
+				mfree += sz // !is_inuse
+			}
+			q += sz
+		}
+	}
+	fp := load32(mem[mallocState+footprint:])
+	if mfree > fp {
+		return 0, 0, false
+	}
+	return fp - mfree, mfree, true
+}
@@ -0,0 +1,14 @@
+package marisa_wasm
+
+// note: this file is not generated, it is kept alongside marisa.go since it
+// needs access to the module globals
+
+// StackPointer returns the current value of the __stack_pointer global.
+func (m *Module) StackPointer() int32 {
+	return m.___stack_pointer
+}
+
+// SetStackPointer sets the __stack_pointer global.
+func (m *Module) SetStackPointer(sp int32) {
+	m.___stack_pointer = sp
+}
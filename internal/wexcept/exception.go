@@ -13,6 +13,7 @@ type Imports interface {
 type Module struct {
 	Memory  wmem.Memory
 	Imports Imports
+	Wrap    func(error) error // optional, converts C++ exceptions before throwing them
 }
 
 // thrownError represents an opaque error thrown from within a host function.
@@ -27,7 +28,8 @@ func (*thrownError) Error() string {
 // Throw throws an error. It should only be called from within a host module
 // function. Note that unlike regular C exceptions, this will not unwind the C++
 // stack properly, which means destructors of local variables will not be
-// executed.
+// executed, and the stack pointer will not be restored (callers should save it
+// before calling into the module, then restore it after catching the error).
 func Throw(err error) {
 	panic(&thrownError{err})
 }
@@ -54,5 +56,8 @@ func (m *Module) Xcxx_throw(typ int32, std int32, what int32) {
 	whatStr, _ := wmem.CString(m.Memory, uint32(what))
 	exc := cxxerr.Wrap(typStr, stdStr, whatStr)
 	m.Imports.Xwexcept_cxx_throw_destroy()
+	if m.Wrap != nil {
+		exc = m.Wrap(exc)
+	}
 	Throw(exc)
 }
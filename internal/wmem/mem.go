@@ -17,6 +17,47 @@ type Memory interface {
 	Free()
 }
 
+type usageMemory interface {
+	usage() (size, committed, reserved uint64)
+	shrink() error
+}
+
+// Usage returns the size of m, the amount of memory allocated for it (which
+// may be more than the size), and the address space reserved for it to grow
+// into (which includes the allocated memory).
+func Usage(m Memory) (size, committed, reserved uint64) {
+	if u, ok := m.(usageMemory); ok {
+		return u.usage()
+	}
+	b := *m.Slice()
+	return uint64(len(b)), uint64(cap(b)), uint64(cap(b))
+}
+
+// Shrink releases memory allocated for m beyond its current size. The reserved
+// address space is kept if m can grow without moving. If not supported by m,
+// it does nothing.
+func Shrink(m Memory) error {
+	if u, ok := m.(usageMemory); ok {
+		return u.shrink()
+	}
+	return nil
+}
+
+type trimMemory interface {
+	trim() error
+}
+
+// Trim releases the address space reserved for m beyond the memory allocated
+// for it. If m is non-movable virtual memory, it can still grow up to the
+// original maximum size afterwards, but it will be moved to a new reservation to
+// do so. If not supported by m, or if files are mapped into it, it does nothing.
+func Trim(m Memory) error {
+	if u, ok := m.(trimMemory); ok {
+		return u.trim()
+	}
+	return nil
+}
+
 func Bytes(m Memory, ptr, n uint32) ([]byte, bool) {
 	d := m.Slice()
 	if d == nil {
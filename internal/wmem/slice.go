@@ -1,5 +1,7 @@
 package wmem
 
+var _ usageMemory = (*sliceMemory)(nil)
+
 type sliceMemory struct {
 	buf []byte
 	max int64
@@ -33,6 +35,17 @@ func (m *sliceMemory) Grow(delta, _ int64) int64 {
 	return old
 }
 
+func (m *sliceMemory) usage() (size, committed, reserved uint64) {
+	return uint64(len(m.buf)), uint64(cap(m.buf)), uint64(cap(m.buf))
+}
+
+func (m *sliceMemory) shrink() error {
+	if len(m.buf) != cap(m.buf) {
+		m.buf = append(make([]byte, 0, len(m.buf)), m.buf...)
+	}
+	return nil
+}
+
 func (m *sliceMemory) Free() {
 	m.buf = nil
 }
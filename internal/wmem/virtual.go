@@ -23,9 +23,18 @@ var virtualMemoryImpl func(cap, max uint64) (Memory, error)
 type mappableMemory interface {
 	Memory
 	pageSize() int
-	mapFile(f *os.File, addr int, offset int64, length int, write bool) error
+	mapFile(f *os.File, addr int, offset int64, length int, write bool, hint MapHint) error
 }
 
+// MapHint is a set of flags controlling how a file is mapped.
+type MapHint uint
+
+const (
+	MapPopulate MapHint = 1 << iota // prefault the pages (MAP_POPULATE or MADV_WILLNEED)
+	MapRandom                       // expect random access (MADV_RANDOM)
+	MapLock                         // lock the pages in memory (mlock)
+)
+
 // MapFile mmaps a file, returning the offset. The module must expose the libc
 // aligned_alloc and free functions. If mem does not support mmapping, an error
 // matching [errors.ErrUnsupported] will be returned. The offset will be aligned
@@ -33,7 +42,7 @@ type mappableMemory interface {
 func MapFile(mod interface {
 	Xaligned_alloc(int32, int32) int32
 	Xfree(int32)
-}, mem Memory, f *os.File, offset, length int64, write bool) (uint32, error) {
+}, mem Memory, f *os.File, offset, length int64, write bool, hint MapHint) (uint32, error) {
 	m, ok := mem.(mappableMemory)
 	if !ok {
 		return 0, fmt.Errorf("%w: module memory does not support mmap", errors.ErrUnsupported)
@@ -62,7 +71,7 @@ func MapFile(mod interface {
 		return 0, errors.New("aligned_alloc returned bad pointer")
 	}
 
-	if err := m.mapFile(f, int(ptr), int64(fof), int(fsz), write); err != nil {
+	if err := m.mapFile(f, int(ptr), int64(fof), int(fsz), write, hint); err != nil {
 		mod.Xfree(int32(ptr))
 		return 0, err
 	}
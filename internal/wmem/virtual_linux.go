@@ -0,0 +1,5 @@
+package wmem
+
+import "golang.org/x/sys/unix"
+
+const mapPopulate = unix.MAP_POPULATE
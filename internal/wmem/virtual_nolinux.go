@@ -0,0 +1,5 @@
+//go:build unix && !linux
+
+package wmem
+
+const mapPopulate = 0 // use MADV_WILLNEED instead
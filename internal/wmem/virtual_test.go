@@ -0,0 +1,221 @@
+package wmem
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVirtualMemory(t *testing.T) {
+	const max = 64 * PageSize
+
+	m, err := VirtualMemory(0, max)
+	if err != nil {
+		if errors.Is(err, errors.ErrUnsupported) {
+			t.Skipf("unsupported platform: %v", err)
+		}
+		t.Fatalf("error: %v", err)
+	}
+	defer m.Free()
+
+	for i, delta := range []int64{0, 1, 2, 0, 5, 56} {
+		old := int64(len(*m.Slice()) >> PageBits)
+		if act := m.Grow(delta, max); act != old {
+			t.Fatalf("grow %d: expected %d, got %d", i, old, act)
+		}
+		b := *m.Slice()
+		if len(b)>>PageBits != int(old+delta) {
+			t.Fatalf("grow %d: expected %d pages, got %d bytes", i, old+delta, len(b))
+		}
+		for j := range b { // ensure it's committed
+			b[j] = byte(j)
+		}
+	}
+	if act := m.Grow(1, max); act != -1 {
+		t.Errorf("expected grow past the reservation to fail, got %d", act)
+	}
+}
+
+func TestVirtualMemoryGrowExact(t *testing.T) {
+	const max = 1 << 30
+
+	m, err := VirtualMemory(0, max)
+	if err != nil {
+		if errors.Is(err, errors.ErrUnsupported) {
+			t.Skipf("unsupported platform: %v", err)
+		}
+		t.Fatalf("error: %v", err)
+	}
+	defer m.Free()
+
+	// the size must not include memory committed ahead of time
+	for i := range int64(1024) {
+		if act := m.Grow(1, max); act != i {
+			t.Fatalf("grow %d: expected %d, got %d", i, i, act)
+		}
+		if n := len(*m.Slice()); n != int(i+1)<<PageBits {
+			t.Fatalf("grow %d: expected %d pages, got %d bytes", i, i+1, n)
+		}
+	}
+}
+
+func TestMemoryShrink(t *testing.T) {
+	const max = 64 * PageSize
+
+	vm, err := VirtualMemory(0, max)
+	if err != nil && !errors.Is(err, errors.ErrUnsupported) {
+		t.Fatalf("error: %v", err)
+	}
+	for _, m := range []Memory{SliceMemory(32*PageSize, max), vm} {
+		if m == nil {
+			continue
+		}
+		defer m.Free()
+
+		if m.Grow(4, max) < 0 {
+			t.Fatalf("failed to grow memory")
+		}
+		for i := range *m.Slice() {
+			(*m.Slice())[i] = 1
+		}
+		if size, committed, reserved := Usage(m); size != 4*PageSize || committed < size || reserved < committed {
+			t.Errorf("%T: incorrect usage: size=%d committed=%d reserved=%d", m, size, committed, reserved)
+		}
+		if err := Shrink(m); err != nil {
+			t.Fatalf("%T: shrink: %v", m, err)
+		}
+		if size, committed, _ := Usage(m); size != 4*PageSize || committed != size {
+			t.Errorf("%T: incorrect usage after shrinking: size=%d committed=%d", m, size, committed)
+		}
+		if m.Grow(4, max) < 0 {
+			t.Fatalf("%T: failed to grow memory after shrinking", m)
+		}
+		for i, x := range *m.Slice() {
+			exp := byte(0) // new memory must be zeroed
+			if i < 4*PageSize {
+				exp = 1
+			}
+			if x != exp {
+				t.Fatalf("%T: incorrect data at %d", m, i)
+			}
+		}
+	}
+}
+
+func TestVirtualMemoryTrim(t *testing.T) {
+	const max = 64 * PageSize
+
+	m, err := VirtualMemory(0, max)
+	if err != nil {
+		if errors.Is(err, errors.ErrUnsupported) {
+			t.Skipf("unsupported platform: %v", err)
+		}
+		t.Fatalf("error: %v", err)
+	}
+	defer m.Free()
+
+	if m.Grow(4, max) < 0 {
+		t.Fatalf("failed to grow memory")
+	}
+	for i := range *m.Slice() {
+		(*m.Slice())[i] = 1
+	}
+	if err := Trim(m); err != nil {
+		t.Fatalf("trim: %v", err)
+	}
+	if size, committed, reserved := Usage(m); size != 4*PageSize || reserved != committed {
+		t.Errorf("incorrect usage after trimming: size=%d committed=%d reserved=%d", size, committed, reserved)
+	}
+
+	// it should be moved to grow past the trimmed reservation
+	for i := range 60 {
+		if m.Grow(1, max) < 0 {
+			t.Fatalf("grow %d: failed to grow memory after trimming", i)
+		}
+	}
+	for i, x := range *m.Slice() {
+		exp := byte(0) // new memory must be zeroed
+		if i < 4*PageSize {
+			exp = 1
+		}
+		if x != exp {
+			t.Fatalf("incorrect data at %d", i)
+		}
+	}
+	if act := m.Grow(1, max); act != -1 {
+		t.Errorf("expected grow past the original reservation to fail, got %d", act)
+	}
+}
+
+func TestMapFile(t *testing.T) {
+	m, err := VirtualMemory(0, 64*PageSize)
+	if err != nil {
+		if errors.Is(err, errors.ErrUnsupported) {
+			t.Skipf("unsupported platform: %v", err)
+		}
+		t.Fatalf("error: %v", err)
+	}
+	defer m.Free()
+
+	if m.Grow(32, 64) < 0 {
+		t.Fatalf("failed to grow memory")
+	}
+
+	psz := m.(mappableMemory).pageSize()
+	if psz <= 0 || psz > PageSize || psz&(psz-1) != 0 {
+		t.Fatalf("invalid page size %d", psz)
+	}
+	t.Logf("page size = %d", psz)
+
+	data := make([]byte, psz*4)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	name := filepath.Join(t.TempDir(), "data")
+	if err := os.WriteFile(name, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	mod := &bumpAlloc{next: PageSize}
+	for _, x := range [][2]int{
+		{0, len(data)},
+		{1, 1},
+		{psz - 1, 2},
+		{psz, psz},
+		{psz + 1, psz*2 - 1},
+		{len(data) - 1, 1},
+	} {
+		off, n := x[0], x[1]
+		ptr, err := MapFile(mod, m, f, int64(off), int64(n), false, MapPopulate|MapRandom)
+		if err != nil {
+			t.Fatalf("map [%d:%d]: %v", off, off+n, err)
+		}
+		b, ok := Bytes(m, ptr, uint32(n))
+		if !ok {
+			t.Fatalf("map [%d:%d]: bad pointer", off, off+n)
+		}
+		if !bytes.Equal(b, data[off:off+n]) {
+			t.Errorf("map [%d:%d]: incorrect data", off, off+n)
+		}
+	}
+}
+
+// bumpAlloc implements aligned_alloc without ever freeing memory.
+type bumpAlloc struct {
+	next int32
+}
+
+func (a *bumpAlloc) Xaligned_alloc(align, size int32) int32 {
+	ptr := (a.next + align - 1) &^ (align - 1)
+	a.next = ptr + size
+	return ptr
+}
+
+func (a *bumpAlloc) Xfree(int32) {}
@@ -12,10 +12,17 @@ import (
 	"golang.org/x/sys/unix"
 )
 
-var _ mappableMemory = (*unixVirtualMemory)(nil)
+var (
+	_ mappableMemory = (*unixVirtualMemory)(nil)
+	_ usageMemory    = (*unixVirtualMemory)(nil)
+	_ trimMemory     = (*unixVirtualMemory)(nil)
+)
 
 type unixVirtualMemory struct {
-	buf []byte // [:committed:reserved]
+	buf []byte // [:size:reserved]
+	com uint64 // committed, >= size
+	max uint64 // maximum reservation, >= reserved
+	fix bool   // files are mapped into it, so it can't be moved
 }
 
 func init() {
@@ -43,7 +50,7 @@ func virtualMemoryUnix(cap, max uint64) (Memory, error) {
 
 	// reserve the full address space (note: protected, private, anon mappings
 	// should not commit memory)
-	b, err := unix.Mmap(-1, 0, int(res), prot, unix.MAP_PRIVATE|unix.MAP_ANON)
+	b, err := mmapAnon(res, prot)
 	if err != nil {
 		switch err {
 		case unix.ENOTSUP, unix.ENOSYS, unix.ENODEV:
@@ -51,7 +58,7 @@ func virtualMemoryUnix(cap, max uint64) (Memory, error) {
 		}
 		return nil, err
 	}
-	return &unixVirtualMemory{buf: b[:com:len(b)]}, nil
+	return &unixVirtualMemory{buf: b[:com:len(b)], com: com, max: res}, nil
 }
 
 func (m *unixVirtualMemory) Slice() *[]byte {
@@ -60,21 +67,24 @@ func (m *unixVirtualMemory) Slice() *[]byte {
 
 func (m *unixVirtualMemory) Grow(delta, _ int64) int64 {
 	var (
-		com = uint64(len(m.buf)) // committed memory
+		com = m.com              // committed memory
 		res = uint64(cap(m.buf)) // address space
 	)
 
-	old := int64(com >> PageBits)
+	old := int64(len(m.buf) >> PageBits)
 	if delta == 0 {
 		return old
 	}
 
 	size := uint64(old+delta) << PageBits
 	if size > res {
-		return -1
+		if size > m.max || m.fix || m.move(size) != nil {
+			return -1
+		}
+		com, res = m.com, uint64(cap(m.buf))
 	}
 
-	// grow the memory if required
+	// commit more memory if required
 	if com < size {
 		// geometrically grow the memory, rounded up to the page size
 		rnd := uint64(unix.Getpagesize() - 1)
@@ -87,17 +97,94 @@ func (m *unixVirtualMemory) Grow(delta, _ int64) int64 {
 		if err != nil {
 			return -1 // failure
 		}
-		m.buf = m.buf[:new]
+		m.com = new
 	}
+
+	// note: the size must be exactly what was requested (and not the committed
+	// size) since the module assumes it is the only one growing the memory
+	m.buf = m.buf[:size]
 	return old
 }
 
+// move moves the memory to a new reservation large enough for size bytes, which
+// is only done if the reservation was trimmed.
+func (m *unixVirtualMemory) move(size uint64) error {
+	var (
+		rnd = uint64(unix.Getpagesize() - 1)
+		res = uint64(cap(m.buf))
+	)
+
+	// geometrically grow the reservation, rounded up to the page size
+	res = min(max(size, res+res>>1), m.max)
+	res = (res + rnd) &^ rnd
+	if res > math.MaxInt {
+		return unix.EOVERFLOW
+	}
+
+	b, err := mmapAnon(res, unix.PROT_NONE)
+	if err != nil {
+		return err
+	}
+	if m.com != 0 {
+		if err := unix.Mprotect(b[:m.com], unix.PROT_READ|unix.PROT_WRITE); err != nil {
+			munmap(b)
+			return err
+		}
+	}
+	copy(b, m.buf)
+
+	if err := munmap(m.buf[:cap(m.buf)]); err != nil {
+		panic(fmt.Errorf("walloc: failed to unmap memory: %w", err))
+	}
+	m.buf = b[:len(m.buf):len(b)]
+	return nil
+}
+
+func (m *unixVirtualMemory) usage() (size, committed, reserved uint64) {
+	return uint64(len(m.buf)), m.com, uint64(cap(m.buf))
+}
+
+func (m *unixVirtualMemory) shrink() error {
+	var (
+		rnd = uint64(unix.Getpagesize() - 1)
+		new = (uint64(len(m.buf)) + rnd) &^ rnd
+	)
+	if new >= m.com {
+		return nil
+	}
+
+	// decommit the memory, but keep the address space reserved (it will be
+	// zero-filled if committed again)
+	b := m.buf[new:m.com]
+	if err := unix.Madvise(b, unix.MADV_DONTNEED); err != nil {
+		return fmt.Errorf("madvise dontneed: %w", err)
+	}
+	if err := unix.Mprotect(b, unix.PROT_NONE); err != nil {
+		return fmt.Errorf("mprotect: %w", err)
+	}
+	m.com = new
+	return nil
+}
+
+func (m *unixVirtualMemory) trim() error {
+	if m.fix || m.com == 0 || m.com == uint64(cap(m.buf)) {
+		return nil
+	}
+
+	// release the address space, but keep the memory growable by moving it
+	if err := munmap(m.buf[m.com:cap(m.buf)]); err != nil {
+		return fmt.Errorf("munmap: %w", err)
+	}
+	m.buf = m.buf[:len(m.buf):m.com]
+	return nil
+}
+
 func (m *unixVirtualMemory) Free() {
 	// note: on unix (unlike windows), it's safe to munmap pages including ones
 	// mapped from a file, so we don't need to keep track of the individual file
 	// mappings and free those first
 
-	err := unix.Munmap(m.buf[:cap(m.buf)])
+	err := munmap(m.buf[:cap(m.buf)])
 	if err != nil {
 		panic(fmt.Errorf("walloc: failed to unmap memory: %w", err))
 	}
@@ -108,7 +195,7 @@ func (m *unixVirtualMemory) pageSize() int {
 	return unix.Getpagesize()
 }
 
-func (m *unixVirtualMemory) mapFile(f *os.File, addr int, offset int64, length int, write bool) error {
+func (m *unixVirtualMemory) mapFile(f *os.File, addr int, offset int64, length int, write bool, hint MapHint) error {
 	var (
 		fd   = f.Fd()
 		rnd  = uint64(unix.Getpagesize() - 1)
@@ -129,8 +216,67 @@ func (m *unixVirtualMemory) mapFile(f *os.File, addr int, offset int64, length i
 	if write {
 		prot |= unix.PROT_WRITE
 	}
-	_, err := unix.MmapPtr(int(fd), offset,
+	flags := unix.MAP_SHARED | unix.MAP_FIXED
+	if hint&MapPopulate != 0 {
+		flags |= mapPopulate
+	}
+	m.fix = true
+	if _, err := unix.MmapPtr(int(fd), offset,
 		unsafe.Pointer(&m.buf[addr]), uintptr(length),
-		prot, unix.MAP_SHARED|unix.MAP_FIXED)
-	return err
+		prot, flags); err != nil {
+		return err
+	}
+
+	b := m.buf[addr : addr+length]
+	if err := mapHint(b, hint); err != nil {
+		// replace the file mapping with anonymous memory again since the caller
+		// will free it
+		if _, err := unix.MmapPtr(-1, 0,
+			unsafe.Pointer(&m.buf[addr]), uintptr(length),
+			unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANON|unix.MAP_FIXED); err != nil {
+			panic(fmt.Errorf("walloc: failed to unmap file: %w", err))
+		}
+		return err
+	}
+	return nil
+}
+
+// mmapAnon reserves n bytes of address space with the specified protection.
+//
+// note: unix.Mmap isn't used since unix.Munmap only accepts the exact slice
+// returned by it, but the reservation may be partially unmapped by trim
+func mmapAnon(n uint64, prot int) ([]byte, error) {
+	p, err := unix.MmapPtr(-1, 0, nil, uintptr(n), prot, unix.MAP_PRIVATE|unix.MAP_ANON)
+	if err != nil {
+		return nil, err
+	}
+	return unsafe.Slice((*byte)(p), n), nil
+}
+
+// munmap unmaps memory reserved by mmapAnon.
+func munmap(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	return unix.MunmapPtr(unsafe.Pointer(unsafe.SliceData(b)), uintptr(len(b)))
+}
+
+// mapHint applies hints to a file mapping.
+func mapHint(b []byte, hint MapHint) error {
+	if hint&MapRandom != 0 {
+		if err := unix.Madvise(b, unix.MADV_RANDOM); err != nil {
+			return fmt.Errorf("madvise random: %w", err)
+		}
+	}
+	if hint&MapPopulate != 0 && mapPopulate == 0 {
+		if err := unix.Madvise(b, unix.MADV_WILLNEED); err != nil {
+			return fmt.Errorf("madvise willneed: %w", err)
+		}
+	}
+	if hint&MapLock != 0 {
+		if err := unix.Mlock(b); err != nil {
+			return fmt.Errorf("mlock: %w", err)
+		}
+	}
+	return nil
 }
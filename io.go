@@ -1,44 +1,170 @@
 package marisa
 
 import (
-	"errors"
 	"io"
+	"io/fs"
 	"math"
 	"os"
+	"path/filepath"
 	"runtime"
 	"slices"
-	"strings"
 
-	"github.com/pgaskin/go-marisa/internal/cxxerr"
 	"github.com/pgaskin/go-marisa/internal/wexcept"
 	"github.com/pgaskin/go-marisa/internal/wmem"
 )
 
-// Open opens a dictionary from a file.
+// Open opens a dictionary from a file. It is memory-mapped if well-supported
+// on the current platform, and read otherwise.
 func Open(name string) (*Trie, error) {
+	t, _, err := open(name, OpenOptions{}, nil)
+	return t, err
+}
+
+// MapPolicy controls whether [OpenWithOptions] memory-maps a dictionary.
+type MapPolicy int
+
+const (
+	MapAuto   MapPolicy = iota // mmap if well-supported on the current platform, falling back to reading the file
+	MapAlways                  // mmap, or fail
+	MapNever                   // read the file
+)
+
+// OpenOptions contains options for [OpenWithOptions]. The hints only apply if
+// the dictionary is memory-mapped.
+type OpenOptions struct {
+	Map      MapPolicy
+	Populate bool // prefault the pages (MAP_POPULATE on linux, MADV_WILLNEED elsewhere)
+	Random   bool // expect random access, disabling readahead (MADV_RANDOM)
+	Lock     bool // lock the pages in memory (mlock), implies MapAlways
+}
+
+// LoadStrategy is how a dictionary was loaded.
+type LoadStrategy int
+
+const (
+	LoadRead LoadStrategy = iota + 1 // read into memory
+	LoadMap                          // memory-mapped
+)
+
+func (s LoadStrategy) String() string {
+	switch s {
+	case LoadRead:
+		return "read"
+	case LoadMap:
+		return "map"
+	}
+	return "unknown"
+}
+
+// mmapTested returns true if mmap (and virtual memory in general) is
+// well-tested on the current platform.
+func mmapTested() bool {
+	switch runtime.GOOS {
+	case "linux", "darwin":
+	default:
+		return false
+	}
+	switch runtime.GOARCH {
+	case "amd64", "arm64", "386", "arm", "riscv64", "ppc64le", "loong64", "mips64le", "mipsle":
+		return true // little-endian
+	}
+	return false
+}
+
+// OpenWithOptions is like [Open], but allows controlling how the dictionary is
+// loaded. It returns the strategy which was used. If the dictionary must be
+// memory-mapped, but it isn't supported on the current platform, an error
+// matching [errors.ErrUnsupported] is returned.
+func OpenWithOptions(name string, opt OpenOptions) (*Trie, LoadStrategy, error) {
+	return open(name, opt, nil)
+}
+
+// open opens a dictionary from a file. If check is not nil, it is called with
+// the raw dictionary data before it is loaded. If it fails for a mapped file
+// and the policy allows it, it falls back to reading the file (and checking it
+// again).
+func open(name string, opt OpenOptions, check func([]byte) error) (*Trie, LoadStrategy, error) {
 	var t Trie
 
-	// only try mmap if it's likely to succeed and it's on a fully tested platform
-	if (runtime.GOOS == "linux" || runtime.GOOS == "darwin") && (runtime.GOARCH == "amd64" || runtime.GOARCH == "arm64") {
+	policy := opt.Map
+	if opt.Lock {
+		policy = MapAlways
+	}
+
+	var hint wmem.MapHint
+	if opt.Populate {
+		hint |= wmem.MapPopulate
+	}
+	if opt.Random {
+		hint |= wmem.MapRandom
+	}
+	if opt.Lock {
+		hint |= wmem.MapLock
+	}
+
+	// only try mmap if it's likely to succeed and it's on a fully tested
+	// platform, unless it's required
+	if policy == MapAlways || (policy == MapAuto && mmapTested()) {
 		// attempt to get the size (and if it's not seekable, it's unlikely to be mappable either)
 		f, err := os.Open(name)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 		defer f.Close()
-		if size, err := f.Seek(0, io.SeekEnd); err == nil {
-			if err := t.MapFile(f, 0, size); err == nil {
-				return &t, nil
-			}
+		size, err := f.Seek(0, io.SeekEnd)
+		if err == nil {
+			err = t.mapFile(f, 0, size, hint, check)
+		}
+		if err == nil {
+			return &t, LoadMap, nil
+		}
+		if policy == MapAlways {
+			return nil, 0, err
 		}
 	}
 
 	// read the entire dictionary
 	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	if check != nil {
+		if err := check(b); err != nil {
+			return nil, 0, err
+		}
+	}
+	if err := t.UnmarshalBinary(b); err != nil {
+		return nil, 0, err
+	}
+	return &t, LoadRead, nil
+}
+
+// OpenFS opens a dictionary from a file in fsys. If the file is an [*os.File]
+// (e.g., from [os.DirFS]), it is memory-mapped if well-supported on the current
+// platform. Otherwise, it is read directly into memory with [Trie.ReadFrom]
+// without making an intermediate copy of the file.
+func OpenFS(fsys fs.FS, name string) (*Trie, error) {
+	f, err := fsys.Open(name)
 	if err != nil {
 		return nil, err
 	}
-	return New(b)
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var t Trie
+	if of, ok := f.(*os.File); ok && mmapTested() && fi.Mode().IsRegular() {
+		if err := t.mapFile(of, 0, fi.Size(), 0, nil); err == nil {
+			return &t, nil
+		}
+	}
+	if _, err := t.readFrom(f, fi.Size()); err != nil {
+		return nil, err
+	}
+	return &t, nil
 }
 
 // New is shorthand for initializing a dictionary with [Trie.UnmarshalBinary].
@@ -65,8 +191,14 @@ func Load(r io.Reader) (*Trie, error) {
 // left unchanged. If not supported by the current platform, an error matching
 // [errors.ErrUnsupported] is returned.
 func (t *Trie) MapFile(f *os.File, offset int64, length int64) error {
+	return t.mapFile(f, offset, length, 0, nil)
+}
+
+// mapFile is like MapFile, but applies the specified hints, and if check is not
+// nil, it is called with the mapped data before the dictionary is loaded.
+func (t *Trie) mapFile(f *os.File, offset int64, length int64, hint wmem.MapHint, check func([]byte) error) (err error) {
 	if uint64(length) > maxAlloc {
-		return errors.New("dictionary too large")
+		return errorKind(ErrTooLarge, nil)
 	}
 	va, err := wmem.VirtualMemory(uint64(length), uint64(length)+scratchSpace)
 	if err != nil {
@@ -76,24 +208,33 @@ func (t *Trie) MapFile(f *os.File, offset int64, length int64) error {
 	if err != nil {
 		return err
 	}
-	ptr, err := wmem.MapFile(mod.marisa, mod.mem, f, offset, length, false)
+	defer func() {
+		if err != nil {
+			mod.Close() // release the mapping immediately
+		}
+	}()
+	ptr, err := wmem.MapFile(mod.marisa, mod.mem, f, offset, length, false, hint)
 	if err != nil {
 		return err
 	}
-	if err := func() (err error) {
-		defer wexcept.Catch(&err)
-		mod.marisa.XNew(int32(ptr), int32(length))
-		return
-	}(); err != nil {
-		var ex *cxxerr.Exception
-		if errors.As(err, &ex) {
-			if errors.Is(ex, cxxerr.Std("runtime_error")) && strings.Contains(ex.What(), "size > avail_") {
-				err = io.ErrUnexpectedEOF
-			}
+	mod.mapped = uint64(length)
+	if check != nil {
+		buf, ok := wmem.Bytes(mod.mem, ptr, uint32(length))
+		if !ok {
+			return internalError("bad allocation")
 		}
+		if err := check(buf); err != nil {
+			return err
+		}
+	}
+	h, err := mod.newTrie(func(p int32) {
+		mod.marisa.XTrieMap(p, int32(ptr), int32(length))
+	})
+	if err != nil {
 		return err
 	}
-	return t.swap(mod)
+	h.data, h.dataLen = ptr, uint32(length)
+	return t.swap(mod, h)
 }
 
 // UnmarshalBinary copies b and maps the trie directly from it. This is faster
@@ -101,7 +242,7 @@ func (t *Trie) MapFile(f *os.File, offset int64, length int64) error {
 // the trie is left unchanged.
 func (t *Trie) UnmarshalBinary(b []byte) error {
 	if uint64(len(b)) > min(math.MaxUint32, math.MaxInt) {
-		return errors.New("dictionary too large")
+		return errorKind(ErrTooLarge, nil)
 	}
 	sa := wmem.SliceMemory(uint64(len(b)), uint64(len(b))+scratchSpace)
 	mod, err := instantiate(sa)
@@ -113,54 +254,71 @@ func (t *Trie) UnmarshalBinary(b []byte) error {
 		return err
 	}
 	if buf, ok := wmem.Bytes(mod.mem, ptr, uint32(len(b))); !ok {
-		panic("bad allocation")
+		return internalError("bad allocation")
 	} else {
 		copy(buf, b)
 	}
-	if err := func() (err error) {
-		defer wexcept.Catch(&err)
-		mod.marisa.XNew(int32(ptr), int32(uint32(len(b))))
-		return
-	}(); err != nil {
-		var ex *cxxerr.Exception
-		if errors.As(err, &ex) {
-			if errors.Is(ex, cxxerr.Std("runtime_error")) && strings.Contains(ex.What(), "size > avail_") {
-				err = io.ErrUnexpectedEOF
-			}
-		}
+	h, err := mod.newTrie(func(p int32) {
+		mod.marisa.XTrieMap(p, int32(ptr), int32(uint32(len(b))))
+	})
+	if err != nil {
 		return err
 	}
-	return t.swap(mod)
+	h.data, h.dataLen = ptr, uint32(len(b))
+	return t.swap(mod, h)
 }
 
 // ReadFrom reads a dictionary from r. On success, it will have read exactly the
 // size of the dictionary. On error, the trie is left unchanged.
 func (t *Trie) ReadFrom(r io.Reader) (int64, error) {
+	return t.readFrom(r, 0)
+}
+
+// readFrom is like ReadFrom, but pre-allocates memory for a dictionary of the
+// specified size if known.
+func (t *Trie) readFrom(r io.Reader, size int64) (n int64, err error) {
 	// note: it won't actually read past in practice, since it reads exactly
 	// what it wants with std::istream::read, and our stream impl is effectively
 	// unbuffered
-	sa := wmem.SliceMemory(8192, maxAlloc)
-	mod, err := instantiate(sa)
+	capacity := uint64(8192)
+	if size > 0 && uint64(size) <= maxAlloc {
+		capacity = uint64(size) + scratchSpace
+	}
+	return t.readFromMemory(r, growableMemory(capacity, maxAlloc))
+}
+
+// readFromMemory is like ReadFrom, but loads the dictionary into a module using
+// mem.
+func (t *Trie) readFromMemory(r io.Reader, mem wmem.Memory) (n int64, err error) {
+	mod, err := instantiate(mem)
 	if err != nil {
 		return 0, err
 	}
+	defer func() {
+		if err != nil {
+			mod.Close() // release the memory immediately
+		}
+	}()
 	c := &countReader{R: r}
-	if err := func() (err error) {
-		defer wexcept.Catch(&err)
-		mod.io.Reader = c
-		defer func() { mod.io.Reader = nil }()
-		mod.marisa.XLoad()
-		return
-	}(); err != nil {
-		var ex *cxxerr.Exception
-		if errors.As(err, &ex) {
-			if errors.Is(ex, cxxerr.Std("runtime_error")) && strings.Contains(ex.What(), "!stream_->read") {
-				err = io.ErrUnexpectedEOF
-			}
+	mod.io.Reader = c
+	h, err := mod.newTrie(func(p int32) {
+		mod.marisa.XTrieLoad(p)
+	})
+	mod.io.Reader = nil
+	if err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errorKind(ErrCorrupt, err) // thrown by Xread
 		}
 		return c.N, err
 	}
-	return c.N, t.swap(mod)
+
+	// the dictionary won't grow any further, so don't keep the rest of the
+	// address space reserved for the trie's whole life (it's only needed for
+	// loading without copying); this is best-effort since the memory is still
+	// usable if it fails
+	_ = wmem.Trim(mod.mem)
+
+	return c.N, t.swap(mod, h)
 }
 
 type zeroReader struct{}
@@ -190,33 +348,41 @@ func (t *Trie) MarshalBinary() ([]byte, error) {
 
 func (t *Trie) AppendBinary(b []byte) ([]byte, error) {
 	if t.mod == nil {
-		return nil, errors.New("dictionary not initialized")
+		return nil, errorKind(ErrNotInitialized, nil)
+	}
+	if err := t.err(); err != nil {
+		return nil, err
 	}
 	b = slices.Grow(b, int(t.ioSize))
 	err := func() (err error) {
 		defer wexcept.Catch(&err)
+		defer t.mod.marisa.SetStackPointer(t.mod.marisa.StackPointer())
 		t.mod.io.WriteBuffer = &b
 		defer func() { t.mod.io.WriteBuffer = nil }()
-		t.mod.marisa.XSave()
+		t.mod.marisa.XTrieSave(int32(t.h.ptr))
 		return
 	}()
-	return b, err
+	return b, t.mod.fault(err)
 }
 
 // WriteTo serializes the dictionary to w.
 func (t *Trie) WriteTo(w io.Writer) (int64, error) {
 	if t.mod == nil {
-		return 0, errors.New("dictionary not initialized")
+		return 0, errorKind(ErrNotInitialized, nil)
+	}
+	if err := t.err(); err != nil {
+		return 0, err
 	}
 	c := &countWriter{W: w}
 	err := func() (err error) {
 		defer wexcept.Catch(&err)
+		defer t.mod.marisa.SetStackPointer(t.mod.marisa.StackPointer())
 		t.mod.io.Writer = c
 		defer func() { t.mod.io.Writer = nil }()
-		t.mod.marisa.XSave()
+		t.mod.marisa.XTrieSave(int32(t.h.ptr))
 		return
 	}()
-	return c.N, err
+	return c.N, t.mod.fault(err)
 }
 
 type countWriter struct {
@@ -230,6 +396,49 @@ func (c *countWriter) Write(p []byte) (n int, err error) {
 	return
 }
 
+// writeFileAtomic calls fn to write a temporary file in the same directory as
+// name, then syncs it and renames it over name. If fn fails, the temporary file
+// is removed and name is left unchanged. If name already exists, its
+// permissions are preserved instead of using perm.
+func writeFileAtomic(name string, perm fs.FileMode, fn func(io.Writer) error) (err error) {
+	if fi, err := os.Stat(name); err == nil {
+		perm = fi.Mode().Perm()
+	}
+	dir := filepath.Dir(name)
+	f, err := os.CreateTemp(dir, "."+filepath.Base(name)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if err := fn(f); err != nil {
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), name); err != nil {
+		return err
+	}
+	// make the rename durable too (this isn't supported on all platforms, and
+	// the file itself is already complete, so ignore errors)
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
 type marisaIOImpl struct {
 	Memory      wmem.Memory
 	Reader      io.Reader
@@ -239,13 +448,13 @@ type marisaIOImpl struct {
 
 func (m *marisaIOImpl) Xread(p, n int32) {
 	if m.Reader == nil {
-		panic("no active reader")
+		wexcept.Throw(internalError("no active reader"))
 	}
 	if n != 0 {
 		if p != 0 {
 			b, ok := wmem.Bytes(m.Memory, uint32(p), uint32(n))
 			if !ok {
-				panic("invalid pointer")
+				wexcept.Throw(internalError("invalid pointer"))
 			}
 			if _, err := io.ReadFull(m.Reader, b); err != nil {
 				if err == io.EOF {
@@ -270,7 +479,7 @@ func (m *marisaIOImpl) Xwrite(p, n int32) {
 			if p != 0 {
 				b, ok := wmem.Bytes(m.Memory, uint32(p), uint32(n))
 				if !ok {
-					panic("invalid pointer")
+					wexcept.Throw(internalError("invalid pointer"))
 				}
 				n, err := w.Write(b)
 				if err != nil {
@@ -292,7 +501,7 @@ func (m *marisaIOImpl) Xwrite(p, n int32) {
 			if p != 0 {
 				x, ok := wmem.Bytes(m.Memory, uint32(p), uint32(n))
 				if !ok {
-					panic("invalid pointer")
+					wexcept.Throw(internalError("invalid pointer"))
 				}
 				*b = append(*b, x...)
 			} else {
@@ -301,5 +510,5 @@ func (m *marisaIOImpl) Xwrite(p, n int32) {
 		}
 		return
 	}
-	panic("no active writer")
+	wexcept.Throw(internalError("no active writer"))
 }
@@ -10,6 +10,7 @@ import (
 	"slices"
 	"syscall"
 	"testing"
+	"testing/fstest"
 
 	"github.com/pgaskin/go-marisa"
 	"github.com/pgaskin/go-marisa/internal/cxxerr"
@@ -274,6 +275,69 @@ func TestIO(t *testing.T) {
 		}
 	})
 
+	t.Run("OpenWithOptions", func(t *testing.T) {
+		for _, tc := range []struct {
+			Name     string
+			Options  marisa.OpenOptions
+			Strategy marisa.LoadStrategy
+		}{
+			{"Never", marisa.OpenOptions{Map: marisa.MapNever}, marisa.LoadRead},
+			{"Always", marisa.OpenOptions{Map: marisa.MapAlways}, marisa.LoadMap},
+			{"Populate", marisa.OpenOptions{Map: marisa.MapAlways, Populate: true}, marisa.LoadMap},
+			{"Random", marisa.OpenOptions{Map: marisa.MapAlways, Random: true}, marisa.LoadMap},
+			{"Lock", marisa.OpenOptions{Lock: true}, marisa.LoadMap},
+			{"NeverPopulate", marisa.OpenOptions{Map: marisa.MapNever, Populate: true, Random: true}, marisa.LoadRead},
+		} {
+			t.Run(tc.Name, func(t *testing.T) {
+				trie, strategy, err := marisa.OpenWithOptions(filename, tc.Options)
+				if err != nil {
+					if errors.Is(err, errors.ErrUnsupported) {
+						t.Skipf("unsupported platform: %v", err)
+					}
+					if tc.Options.Lock && (errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.ENOMEM) || errors.Is(err, syscall.EAGAIN)) {
+						t.Skipf("mlock not permitted: %v", err)
+					}
+					t.Fatalf("error: %v", err)
+				}
+				if strategy != tc.Strategy {
+					t.Errorf("expected strategy %s, got %s", tc.Strategy, strategy)
+				}
+				if !checkTrie(trie) {
+					t.Errorf("round-trip failed")
+				}
+			})
+		}
+
+		if trie, strategy, err := marisa.OpenWithOptions(filepath.Join(t.TempDir(), "nonexistent"), marisa.OpenOptions{Map: marisa.MapAlways}); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected not found, got: %v", err)
+		} else if trie != nil || strategy != 0 {
+			t.Errorf("expected trie and strategy to be zero if not found")
+		}
+	})
+
+	t.Run("OpenFS", func(t *testing.T) {
+		for _, tc := range []struct {
+			Name string
+			FS   fs.FS
+		}{
+			{"DirFS", os.DirFS(filepath.Dir(filename))},
+			{"MapFS", fstest.MapFS{filepath.Base(filename): &fstest.MapFile{Data: expected}}},
+		} {
+			t.Run(tc.Name, func(t *testing.T) {
+				if trie, err := marisa.OpenFS(tc.FS, filepath.Base(filename)); err != nil {
+					t.Errorf("error: %v", err)
+				} else if !checkTrie(trie) {
+					t.Errorf("round-trip failed")
+				}
+				if trie, err := marisa.OpenFS(tc.FS, "nonexistent"); !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("expected not found, got: %v", err)
+				} else if trie != nil {
+					t.Errorf("expected trie to be nil if not found")
+				}
+			})
+		}
+	})
+
 	t.Run("New", func(t *testing.T) {
 		if trie, err := marisa.New(expected); err != nil {
 			t.Errorf("error: %v", err)
@@ -0,0 +1,82 @@
+package marisa
+
+import "cmp"
+
+// Keyset is a set of keys and weights which can be built into dictionaries
+// multiple times (e.g., with different configurations) without adding the keys
+// again. More keys can be added after building.
+//
+// Since MARISA overwrites the weights while building, each build uses a copy of
+// the module containing the keys, so building temporarily needs about twice the
+// memory used by the keyset.
+//
+// A Keyset must not be used concurrently.
+type Keyset struct {
+	noCopy noCopy
+	b      *Builder
+}
+
+// NewKeyset creates a new empty [Keyset].
+func NewKeyset() (*Keyset, error) {
+	b, err := NewBuilder(BuilderOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &Keyset{b: b}, nil
+}
+
+// Add adds a key with the specified weight. If a key is added multiple times,
+// the weights are accumulated.
+func (k *Keyset) Add(key string, weight float32) error {
+	return k.b.Add(key, weight)
+}
+
+// AddBytes is like [Keyset.Add], but takes the key as a byte slice.
+func (k *Keyset) AddBytes(key []byte, weight float32) error {
+	return k.b.AddBytes(key, weight)
+}
+
+// Len returns the number of keys added, including duplicates.
+func (k *Keyset) Len() int {
+	return k.b.Len()
+}
+
+// MemoryUsage returns the amount of module memory used by the keyset. After the
+// keyset is closed, it returns zero.
+func (k *Keyset) MemoryUsage() uint64 {
+	return k.b.MemoryUsage()
+}
+
+// Close releases the memory used by the keyset. If it was already closed, it
+// returns an error matching [ErrClosed].
+func (k *Keyset) Close() error {
+	return k.b.Close()
+}
+
+// BuildKeyset builds a dictionary out of the keys in k. The keyset is not
+// modified, and can still be used afterwards. On error, the trie is left
+// unchanged.
+func (t *Trie) BuildKeyset(k *Keyset, cfg Config) error {
+	b := k.b
+	if b.err != nil {
+		return b.err
+	}
+	flag, ok := configFlags(cfg)
+	if !ok {
+		return errorKind(ErrInvalidConfig, nil)
+	}
+	mod, err := b.mod.clone(growableMemory(k.MemoryUsage(), maxAlloc))
+	if err != nil {
+		return err
+	}
+	var built Trie
+	if err := b.xbuild(mod, &built, flag); err != nil {
+		mod.Close()
+		return err
+	}
+	if err := t.loadBuilt(&built, maxAlloc); err != nil {
+		return err
+	}
+	t.cacheLevel = cmp.Or(cfg.CacheLevel, NormalCache)
+	return nil
+}
@@ -0,0 +1,88 @@
+package marisa_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/pgaskin/go-marisa"
+	"github.com/pgaskin/go-marisa/testdata"
+)
+
+func TestKeyset(t *testing.T) {
+	ks, err := marisa.NewKeyset()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	for i, word := range testdata.Words {
+		if i%2 == 0 {
+			err = ks.Add(word, 1)
+		} else {
+			err = ks.AddBytes([]byte(word), 1)
+		}
+		if err != nil {
+			t.Fatalf("add %q: %v", word, err)
+		}
+	}
+	if act := ks.Len(); act != len(testdata.Words) {
+		t.Errorf("expected %d keys, got %d", len(testdata.Words), act)
+	}
+
+	usage := ks.MemoryUsage()
+	for _, numTries := range []int{1, 3, 5} {
+		for _, order := range []marisa.NodeOrder{marisa.LabelOrder, marisa.WeightOrder} {
+			cfg := marisa.Config{NumTries: numTries, NodeOrder: order}
+
+			var exp marisa.Trie
+			if err := exp.Build(func(yield func(string) bool) {
+				for _, word := range testdata.Words {
+					if !yield(word) {
+						return
+					}
+				}
+			}, cfg); err != nil {
+				t.Fatalf("build %+v: %v", cfg, err)
+			}
+			expected, err := exp.MarshalBinary()
+			if err != nil {
+				t.Fatalf("marshal %+v: %v", cfg, err)
+			}
+
+			var trie marisa.Trie
+			if err := trie.BuildKeyset(ks, cfg); err != nil {
+				t.Fatalf("build keyset %+v: %v", cfg, err)
+			}
+			if buf, err := trie.MarshalBinary(); err != nil {
+				t.Fatalf("marshal %+v: %v", cfg, err)
+			} else if !bytes.Equal(buf, expected) {
+				t.Errorf("build keyset %+v: incorrect dictionary", cfg)
+			}
+		}
+	}
+
+	if act := ks.MemoryUsage(); act != usage {
+		t.Errorf("expected building to leave the keyset unchanged, but memory usage went from %d to %d", usage, act)
+	}
+
+	var trie marisa.Trie
+	if err := trie.BuildKeyset(ks, marisa.Config{NumTries: -1}); !errors.Is(err, marisa.ErrInvalidConfig) {
+		t.Errorf("expected invalid config error, got %v", err)
+	}
+
+	if err := ks.Add("zzzzzzzzzz", 1); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := trie.BuildKeyset(ks, marisa.Config{}); err != nil {
+		t.Fatalf("build keyset: %v", err)
+	}
+	if exp, act := uint32(len(testdata.Words)+1), trie.Size(); act != exp {
+		t.Errorf("expected %d keys after adding more, got %d", exp, act)
+	}
+
+	if err := ks.Close(); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if err := trie.BuildKeyset(ks, marisa.Config{}); !errors.Is(err, marisa.ErrClosed) {
+		t.Errorf("expected closed error, got %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package marisa
+
+import (
+	"errors"
+
+	"github.com/pgaskin/go-marisa/internal/wmem"
+)
+
+// MemoryStats describes the memory used by a loaded dictionary, including
+// what [Trie.TotalSize] doesn't account for (the unused heap, the scratch space
+// reserved while loading, cached queries, and the module's own stack and data).
+type MemoryStats struct {
+	// Size is the size of the module memory.
+	Size uint64
+
+	// Committed is the amount of memory allocated for the module memory. It
+	// includes memory mapped from a file, and may be larger than the size if
+	// space was reserved for it to grow into.
+	Committed uint64
+
+	// Reserved is the amount of address space reserved for the module memory
+	// to grow into without moving, including the committed memory. Reserved
+	// address space which isn't committed doesn't use any memory.
+	Reserved uint64
+
+	// Mapped is the amount of module memory mapped from a file. It is backed by
+	// the page cache rather than anonymous memory, so it can be shared between
+	// processes and reclaimed by the OS.
+	Mapped uint64
+
+	// Heap is the amount of module memory not mapped from a file. This includes
+	// HeapInUse, HeapFree, and the module's own stack and data.
+	Heap uint64
+
+	// HeapInUse is the amount of module memory allocated by the module, other
+	// than memory mapped from a file. This includes the dictionary (if not
+	// mapped) and queries.
+	HeapInUse uint64
+
+	// HeapFree is the amount of module memory which is free, and can be reused
+	// by the module without growing the module memory.
+	HeapFree uint64
+}
+
+// MemoryStats returns information about the memory used by the dictionary. If
+// the trie is not initialized or closed, it returns the zero value.
+//
+// If the trie is in a [TrieSet], the module memory is shared with the other
+// dictionaries in the set, so only HeapInUse is set, to the memory allocated
+// for the copy of the dictionary and the trie mapped from it. Use
+// [TrieSet.MemoryStats] for the memory used by the entire set.
+func (t *Trie) MemoryStats() MemoryStats {
+	if t.mod == nil || errors.Is(t.err(), ErrClosed) {
+		return MemoryStats{}
+	}
+	if t.h.set != nil {
+		return MemoryStats{HeapInUse: t.h.heap}
+	}
+	return t.mod.memoryStats()
+}
+
+// memoryStats returns information about the memory used by the module.
+func (m *module) memoryStats() MemoryStats {
+	size, committed, reserved := wmem.Usage(m.mem)
+	s := MemoryStats{
+		Size:      size,
+		Committed: committed,
+		Reserved:  reserved,
+		Mapped:    m.mapped,
+		Heap:      size - min(size, m.mapped),
+	}
+	if inUse, free, ok := m.marisa.MallocStats(); ok {
+		s.HeapInUse = uint64(inUse) - min(uint64(inUse), m.mapped)
+		s.HeapFree = uint64(free)
+	}
+	return s
+}
+
+// ShrinkMemory releases memory committed and address space reserved for the
+// module memory to grow into, like the scratch space reserved while loading a
+// dictionary. The module memory can still grow afterwards (e.g., for new
+// queries), but it may need to be moved or committed again.
+//
+// If the module memory isn't backed by virtual memory (e.g., if loaded by
+// [Trie.UnmarshalBinary]), it is copied, so this temporarily needs twice the
+// memory.
+func (t *Trie) ShrinkMemory() error {
+	if t.mod == nil {
+		return errorKind(ErrNotInitialized, nil)
+	}
+	if err := t.err(); err != nil {
+		return err
+	}
+	if err := wmem.Shrink(t.mod.mem); err != nil {
+		return err
+	}
+	return wmem.Trim(t.mod.mem)
+}
@@ -0,0 +1,151 @@
+package marisa_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/pgaskin/go-marisa"
+	"github.com/pgaskin/go-marisa/internal"
+	"github.com/pgaskin/go-marisa/testdata"
+)
+
+func TestMemoryStats(t *testing.T) {
+	data := mustWordsTrieData()
+
+	var trie marisa.Trie
+	if act := trie.MemoryStats(); act != (marisa.MemoryStats{}) {
+		t.Errorf("expected zero stats for uninitialized trie, got %+v", act)
+	}
+	if err := trie.ShrinkMemory(); !errors.Is(err, marisa.ErrNotInitialized) {
+		t.Errorf("expected not initialized error, got %v", err)
+	}
+
+	checkStats := func(t *testing.T, s marisa.MemoryStats) {
+		t.Helper()
+		if s.Size < uint64(len(data)) {
+			t.Errorf("expected size to include the dictionary, got %+v", s)
+		}
+		if s.Committed < s.Size || s.Reserved < s.Committed {
+			t.Errorf("inconsistent stats: %+v", s)
+		}
+		if s.Heap+s.Mapped != s.Size {
+			t.Errorf("expected heap and mapped memory to add up to the size, got %+v", s)
+		}
+		if s.HeapInUse == 0 || s.HeapInUse+s.HeapFree > s.Heap {
+			t.Errorf("expected the heap to include the allocated and free memory, got %+v", s)
+		}
+	}
+
+	t.Run("Read", func(t *testing.T) {
+		// force slice-backed memory, which allocates the scratch space up-front
+		defer func(v bool) { internal.NoVirtualMemory = v }(internal.NoVirtualMemory)
+		internal.NoVirtualMemory = true
+
+		trie, err := marisa.OpenFS(fstest.MapFS{"words.dic": {Data: data}}, "words.dic")
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		defer trie.Close()
+
+		s := trie.MemoryStats()
+		checkStats(t, s)
+		if s.Mapped != 0 {
+			t.Errorf("expected no mapped memory, got %+v", s)
+		}
+		if s.HeapInUse < uint64(trie.TotalSize()) {
+			t.Errorf("expected the dictionary to be allocated, got %+v", s)
+		}
+		if s.Committed-s.Size < 16<<20 {
+			t.Errorf("expected scratch space to be committed, got %+v", s)
+		}
+
+		if err := trie.ShrinkMemory(); err != nil {
+			t.Fatalf("shrink: %v", err)
+		}
+		s = trie.MemoryStats()
+		checkStats(t, s)
+		if s.Committed != s.Size {
+			t.Errorf("expected scratch space to be released, got %+v", s)
+		}
+		if buf, err := trie.MarshalBinary(); err != nil {
+			t.Errorf("error: %v", err)
+		} else if !bytes.Equal(buf, data) {
+			t.Errorf("round-trip failed after shrinking")
+		}
+		for _, key := range testdata.Words[:1000] {
+			if _, ok, err := trie.Lookup(key); err != nil || !ok {
+				t.Fatalf("lookup %q failed after shrinking: %v", key, err)
+			}
+		}
+
+		trie.Close()
+		if act := trie.MemoryStats(); act != (marisa.MemoryStats{}) {
+			t.Errorf("expected zero stats for closed trie, got %+v", act)
+		}
+		if err := trie.ShrinkMemory(); !errors.Is(err, marisa.ErrClosed) {
+			t.Errorf("expected closed error, got %v", err)
+		}
+	})
+	t.Run("ReadVirtual", func(t *testing.T) {
+		trie, err := marisa.OpenFS(fstest.MapFS{"words.dic": {Data: data}}, "words.dic")
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		defer trie.Close()
+
+		s := trie.MemoryStats()
+		checkStats(t, s)
+		if s.Reserved != s.Committed {
+			t.Errorf("expected address space not to be reserved after loading, got %+v", s)
+		}
+		for _, key := range testdata.Words {
+			if _, ok, err := trie.Lookup(key); err != nil || !ok {
+				t.Fatalf("lookup %q failed: %v", key, err)
+			}
+		}
+	})
+	t.Run("Map", func(t *testing.T) {
+		name := filepath.Join(t.TempDir(), "words.dic")
+		if err := os.WriteFile(name, data, 0666); err != nil {
+			t.Fatal(err)
+		}
+		trie, _, err := marisa.OpenWithOptions(name, marisa.OpenOptions{Map: marisa.MapAlways})
+		if err != nil {
+			if errors.Is(err, errors.ErrUnsupported) {
+				t.Skipf("unsupported platform: %v", err)
+			}
+			t.Fatalf("error: %v", err)
+		}
+		defer trie.Close()
+
+		s := trie.MemoryStats()
+		checkStats(t, s)
+		if s.Mapped != uint64(len(data)) {
+			t.Errorf("expected the dictionary to be mapped, got %+v", s)
+		}
+		if s.HeapInUse >= uint64(len(data)) {
+			t.Errorf("expected the mapped dictionary not to be counted as allocated, got %+v", s)
+		}
+		if s.Reserved-s.Size < 16<<20 {
+			t.Errorf("expected scratch space to be reserved, got %+v", s)
+		}
+
+		if err := trie.ShrinkMemory(); err != nil {
+			t.Fatalf("shrink: %v", err)
+		}
+		s = trie.MemoryStats()
+		checkStats(t, s)
+		if s.Committed != s.Size {
+			t.Errorf("expected committed memory to be released, got %+v", s)
+		}
+		if buf, err := trie.MarshalBinary(); err != nil {
+			t.Errorf("error: %v", err)
+		} else if !bytes.Equal(buf, data) {
+			t.Errorf("round-trip failed after shrinking")
+		}
+	})
+}
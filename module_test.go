@@ -0,0 +1,425 @@
+package marisa
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/pgaskin/go-marisa/internal/layout"
+	"github.com/pgaskin/go-marisa/internal/marisa_wasm"
+	"github.com/pgaskin/go-marisa/internal/wexcept"
+	"github.com/pgaskin/go-marisa/internal/wmem"
+	"github.com/pgaskin/go-marisa/testdata"
+)
+
+// TestThrowLeak ensures errors thrown through the module don't leak memory or
+// stack space.
+func TestThrowLeak(t *testing.T) {
+	var trie Trie
+	if err := trie.Build(slices.Values(testdata.Words), Config{}); err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	expected, err := trie.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	// checkLeak calls fn repeatedly, checking that it fails with an error
+	// matching target, and that the stack pointer and allocated memory are
+	// the same after each call.
+	checkLeak := func(t *testing.T, mod *module, n int, target error, fn func(i int) error) {
+		t.Helper()
+		if err := fn(0); !errors.Is(err, target) { // warm up any cached state
+			t.Fatalf("expected error matching %v, got %v", target, err)
+		}
+		sp := mod.marisa.StackPointer()
+		mem := mod.memoryStats()
+		for i := range n {
+			if err := fn(i); !errors.Is(err, target) {
+				t.Fatalf("iteration %d: expected error matching %v, got %v", i, target, err)
+			}
+			if cur := mod.marisa.StackPointer(); cur != sp {
+				t.Fatalf("iteration %d: stack pointer changed from %d to %d", i, sp, cur)
+			}
+			if cur := mod.memoryStats(); cur.Size != mem.Size || cur.HeapInUse != mem.HeapInUse {
+				t.Fatalf("iteration %d: module memory changed from %+v to %+v", i, mem, cur)
+			}
+		}
+	}
+
+	t.Run("Write", func(t *testing.T) {
+		errWrite := errors.New("write failed")
+		checkLeak(t, trie.mod, 100000, errWrite, func(i int) error {
+			_, err := trie.WriteTo(&failWriter{N: int64(i % 4096), Err: errWrite})
+			return err
+		})
+	})
+	t.Run("Query", func(t *testing.T) {
+		checkLeak(t, trie.mod, 10000, ErrCorrupt, func(i int) error {
+			q, err := trie.queryString(testdata.Words[i%len(testdata.Words)][:1])
+			if err != nil {
+				return err
+			}
+			_, err = q.Next(func(m *marisa_wasm.Module, tp, qp int32) int32 {
+				m.XTriePredictiveSearch(tp, qp) // allocates the agent state
+				m.XBuildPush(0, 1, 0)           // throws std::invalid_argument
+				return 0
+			})
+			trie.queryDone(q, &err)
+			return err
+		})
+	})
+	t.Run("ReadFrom", func(t *testing.T) {
+		// loading always uses a new module, so this must leave the existing one
+		// alone (and the new one is discarded on error)
+		checkLeak(t, trie.mod, 1000, ErrCorrupt, func(i int) error {
+			_, err := trie.ReadFrom(bytes.NewReader(expected[:i*len(expected)/1000]))
+			return err
+		})
+	})
+	t.Run("UnmarshalBinary", func(t *testing.T) {
+		checkLeak(t, trie.mod, 1000, ErrCorrupt, func(i int) error {
+			return trie.UnmarshalBinary(expected[:i*len(expected)/1000])
+		})
+	})
+	t.Run("TrieSet", func(t *testing.T) {
+		// but dictionaries in a set are mapped in the shared module
+		set, err := NewTrieSet()
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		defer set.Close()
+		checkLeak(t, set.mod, 1000, ErrCorrupt, func(i int) error {
+			// largest first, so the copy doesn't need to grow the memory
+			_, err := set.Load(expected[:len(expected)-1-i*len(expected)/1000])
+			return err
+		})
+	})
+	t.Run("BuildPush", func(t *testing.T) {
+		b, err := NewBuilder(BuilderOptions{})
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		defer b.Close()
+		for _, key := range testdata.Words[:1000] {
+			if err := b.Add(key, 1); err != nil {
+				t.Fatalf("add: %v", err)
+			}
+		}
+		checkLeak(t, b.mod, 10000, ErrCorrupt, func(i int) error {
+			return func() (err error) {
+				defer wexcept.Catch(&err)
+				defer b.mod.marisa.SetStackPointer(b.mod.marisa.StackPointer())
+				b.mod.marisa.XBuildPush(0, int32(1+i), 1) // throws std::invalid_argument
+				return
+			}()
+		})
+	})
+
+	if buf, err := trie.MarshalBinary(); err != nil {
+		t.Errorf("marshal: %v", err)
+	} else if !slices.Equal(buf, expected) {
+		t.Errorf("dictionary changed after errors")
+	}
+	for id, key := range testdata.Words[:1000] {
+		if act, ok, err := trie.ReverseLookup(uint32(id)); err != nil || !ok {
+			t.Fatalf("reverse lookup %d: %v", id, err)
+		} else if _, ok, err := trie.Lookup(act); err != nil || !ok {
+			t.Fatalf("lookup %q: %v", key, err)
+		}
+	}
+}
+
+// TestBuildCompact ensures built dictionaries don't retain the build memory.
+func TestBuildCompact(t *testing.T) {
+	var built Trie
+	if err := built.Build(slices.Values(testdata.Words), Config{}); err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	buf, err := built.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	var loaded Trie
+	if err := loaded.UnmarshalBinary(buf); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if exp, act := loaded.TotalSize(), built.TotalSize(); act != exp {
+		t.Errorf("expected total size to be %d, got %d", exp, act)
+	}
+	if exp, act := len(*loaded.mod.mem.Slice()), len(*built.mod.mem.Slice()); act != exp {
+		t.Errorf("expected module memory to be %d, got %d", exp, act)
+	}
+}
+
+// TestMallocStats ensures the allocator stats track allocations.
+func TestMallocStats(t *testing.T) {
+	mod, err := instantiate(growableMemory(0, maxAlloc))
+	if err != nil {
+		t.Fatalf("instantiate: %v", err)
+	}
+	defer mod.Close()
+
+	if _, err := mod.Alloc(1); err != nil { // initialize the allocator
+		t.Fatalf("alloc: %v", err)
+	}
+	inUse, free, ok := mod.marisa.MallocStats()
+	if !ok {
+		t.Fatalf("failed to read allocator stats")
+	}
+	var ptrs []uint32
+	for i := range 64 {
+		n := 1 + i*i*997
+		ptr, err := mod.Alloc(n)
+		if err != nil {
+			t.Fatalf("alloc: %v", err)
+		}
+		ptrs = append(ptrs, ptr)
+
+		cur, _, ok := mod.marisa.MallocStats()
+		if !ok {
+			t.Fatalf("failed to read allocator stats")
+		}
+		if cur < inUse+uint32(n) || cur > inUse+uint32(n)+32 {
+			t.Fatalf("alloc %d: expected %d bytes to be allocated, got %d", n, inUse+uint32(n), cur)
+		}
+		inUse = cur
+	}
+	for i, ptr := range ptrs {
+		if i%2 != 0 {
+			mod.Free(ptr)
+		}
+	}
+	if cur, curFree, _ := mod.marisa.MallocStats(); cur >= inUse || curFree <= free {
+		t.Errorf("expected freed memory to be counted as free, got %d in use and %d free", cur, curFree)
+	}
+}
+
+// TestLevelSize ensures the level sizes read from the module match the
+// serialized dictionary.
+func TestLevelSize(t *testing.T) {
+	for _, cfg := range []Config{
+		{},
+		{NumTries: 1, CacheLevel: TinyCache},
+		{NumTries: 5, CacheLevel: HugeCache, TailMode: BinaryTail},
+	} {
+		t.Run(cfg.String(), func(t *testing.T) {
+			var trie Trie
+			if err := trie.Build(slices.Values(testdata.Words), cfg); err != nil {
+				t.Fatalf("build: %v", err)
+			}
+			defer trie.Close()
+
+			buf, err := trie.MarshalBinary()
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			l, err := layout.Parse(bytes.NewReader(buf), int64(len(buf)), binary.LittleEndian, 8)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			exp := layoutBreakdown(l)
+
+			act, err := trie.SizeBreakdown()
+			if err != nil {
+				t.Fatalf("breakdown: %v", err)
+			}
+			if !slices.Equal(act, exp) {
+				t.Errorf("expected %+v, got %+v", exp, act)
+			}
+
+			trie.cacheLevel = 0
+			if act, exp := trie.Config().CacheLevel, inferCacheLevel(l.NumKeys(), l.Cache.Len()); act != exp {
+				t.Errorf("expected cache level %s, got %s", exp, act)
+			}
+
+			if _, err := trie.levelSize(trie.numTries); !errors.Is(err, ErrInternal) {
+				t.Errorf("expected internal error for a level past the end, got %v", err)
+			}
+		})
+	}
+}
+
+// layoutBreakdown returns the size of each level of the dictionary from its
+// layout.
+func layoutBreakdown(l *layout.Trie) []LevelSize {
+	var s []LevelSize
+	for ; l != nil; l = l.Next {
+		s = append(s, LevelSize{
+			Louds:         uint32(l.Louds.TotalSize()),
+			TerminalFlags: uint32(l.TerminalFlags.TotalSize()),
+			LinkFlags:     uint32(l.LinkFlags.TotalSize()),
+			Bases:         uint32(l.Bases.Size),
+			Extras:        uint32(l.Extras.TotalSize()),
+			Tail:          uint32(l.Tail.TotalSize()),
+			Cache:         uint32(l.Cache.Size),
+		})
+	}
+	return s
+}
+
+// failWriter fails after writing N bytes.
+type failWriter struct {
+	N   int64
+	Err error
+}
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > w.N {
+		n := int(w.N)
+		w.N = 0
+		return n, w.Err
+	}
+	w.N -= int64(len(p))
+	return len(p), nil
+}
+
+// TestFault ensures internal errors are returned rather than panicking, and
+// make the trie unusable.
+func TestFault(t *testing.T) {
+	var trie Trie
+	if err := trie.Build(slices.Values(testdata.Words), Config{}); err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	buf, err := trie.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	assertInternal := func(t *testing.T, name string, err error) {
+		t.Helper()
+		if !errors.Is(err, ErrInternal) {
+			t.Errorf("%s: expected internal error, got %v", name, err)
+		} else {
+			t.Logf("%s: %v", name, err)
+		}
+	}
+
+	// only let the module see the memory when it is instantiated
+	wrapMemory = func(m wmem.Memory) wmem.Memory {
+		return &faultMemory{Memory: m, N: 1}
+	}
+	t.Run("UnmarshalBinary", func(t *testing.T) {
+		var trie Trie
+		assertInternal(t, "UnmarshalBinary", trie.UnmarshalBinary(buf))
+		if trie.mod != nil {
+			t.Errorf("trie should be left unchanged on error")
+		}
+	})
+	t.Run("Build", func(t *testing.T) {
+		var trie Trie
+		assertInternal(t, "Build", trie.Build(slices.Values(testdata.Words), Config{}))
+		if trie.mod != nil {
+			t.Errorf("trie should be left unchanged on error")
+		}
+	})
+	wrapMemory = nil
+
+	newFaulty := func() *Trie {
+		var trie Trie
+		if err := trie.UnmarshalBinary(buf); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		trie.mod.mem = &faultMemory{Memory: trie.mod.mem}
+		trie.mod.io.Memory = trie.mod.mem
+		return &trie
+	}
+	assertUnusable := func(t *testing.T, trie *Trie) {
+		t.Helper()
+		_, _, err := trie.Lookup("test")
+		assertInternal(t, "Lookup", err)
+		_, err = trie.WriteTo(io.Discard)
+		assertInternal(t, "WriteTo", err)
+	}
+
+	t.Run("Write", func(t *testing.T) {
+		trie := newFaulty()
+		_, err := trie.WriteTo(io.Discard)
+		assertInternal(t, "WriteTo", err)
+		assertUnusable(t, trie)
+	})
+	t.Run("Read", func(t *testing.T) {
+		var trie Trie
+		if err := trie.UnmarshalBinary(buf); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		err := func() (err error) {
+			defer wexcept.Catch(&err)
+			defer trie.mod.marisa.SetStackPointer(trie.mod.marisa.StackPointer())
+			trie.mod.marisa.XTrieLoad(int32(trie.h.ptr)) // no reader
+			return
+		}()
+		assertInternal(t, "XLoad", err)
+	})
+	t.Run("Query", func(t *testing.T) {
+		trie := newFaulty()
+		_, _, err := trie.Lookup("test")
+		assertInternal(t, "Lookup", err)
+		assertUnusable(t, trie)
+	})
+	t.Run("Trap", func(t *testing.T) {
+		var trie Trie
+		if err := trie.UnmarshalBinary(buf); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		sp := trie.mod.marisa.StackPointer()
+		q, err := trie.queryString("test")
+		if err != nil {
+			t.Fatalf("query: %v", err)
+		}
+		_, err = q.Next(func(m *marisa_wasm.Module, _, p int32) int32 {
+			m.SetStackPointer(m.StackPointer() - 64)
+			var oob []byte
+			return int32(oob[p]) // like an out-of-bounds access to the module memory
+		})
+		trie.queryDone(q, &err)
+		if !errors.Is(err, ErrCorrupt) {
+			t.Errorf("expected corrupt error, got %v", err)
+		}
+		if act := trie.mod.marisa.StackPointer(); act != sp {
+			t.Errorf("expected stack pointer to be restored")
+		}
+		if _, _, err := trie.Lookup("test"); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("expected lookup to fail after trap, got %v", err)
+		}
+		if err := trie.Verify(); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("expected verify to fail after trap, got %v", err)
+		}
+	})
+	t.Run("QueryDone", func(t *testing.T) {
+		var trie Trie
+		if err := trie.UnmarshalBinary(buf); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		q, err := trie.queryID(0)
+		if err != nil {
+			t.Fatalf("query: %v", err)
+		}
+		trie.queryDone(q, &err)
+		if err != nil {
+			t.Fatalf("query done: %v", err)
+		}
+		q.ptr = 0
+		trie.queryDone(q, &err)
+		assertInternal(t, "queryDone", err)
+		assertUnusable(t, &trie)
+	})
+}
+
+// faultMemory hides the memory after it has been accessed N times.
+type faultMemory struct {
+	wmem.Memory
+	N int
+}
+
+func (m *faultMemory) Slice() *[]byte {
+	if m.N > 0 {
+		m.N--
+		return m.Memory.Slice()
+	}
+	return new([]byte)
+}
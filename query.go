@@ -14,6 +14,7 @@ import (
 type query struct {
 	noCopy   noCopy
 	mod      *module
+	h        *handle // the dictionary being queried
 	ptr      uint32
 	shortStr uint32 // pre-allocated shortQueryLen
 	longStr  uint32
@@ -27,20 +28,26 @@ func (t *Trie) query() (*query, error) {
 		return nil, nil
 	}
 
+	if err := t.err(); err != nil {
+		return nil, err
+	}
+
 	var q *query
-	if !internal.NoCacheQuery && t.qry != nil {
-		q, t.qry = t.qry, nil
+	if !internal.NoCacheQuery && t.h.qry != nil {
+		q, t.h.qry = t.h.qry, nil
 	} else {
 		ptr, err := func() (ptr uint32, err error) {
 			defer wexcept.Catch(&err)
+			defer t.mod.marisa.SetStackPointer(t.mod.marisa.StackPointer())
 			ptr = uint32(t.mod.marisa.XQueryNew())
 			return
 		}()
 		if err != nil {
-			return nil, err
+			return nil, t.mod.fault(err)
 		}
 		q = &query{
 			mod: t.mod,
+			h:   t.h,
 			ptr: ptr,
 		}
 	}
@@ -63,7 +70,7 @@ func (t *Trie) queryString(s string) (*query, error) {
 		if q.shortStr == 0 {
 			q.shortStr, err = t.mod.Alloc(shortQueryLen)
 			if err != nil {
-				t.queryDone(q)
+				t.queryDone(q, &err)
 				return nil, err
 			}
 		}
@@ -71,23 +78,27 @@ func (t *Trie) queryString(s string) (*query, error) {
 	} else {
 		str, err = t.mod.Alloc(len(s))
 		if err != nil {
-			t.queryDone(q)
+			t.queryDone(q, &err)
 			return nil, err
 		}
 		q.longStr = str
 	}
 	if buf, ok := wmem.Bytes(t.mod.mem, str, uint32(len(s))); !ok {
-		panic("bad allocation")
+		err := t.mod.fault(internalError("bad allocation"))
+		t.queryDone(q, &err)
+		return nil, err
 	} else {
 		copy(buf, s)
 	}
 
 	if err := func() (err error) {
 		defer wexcept.Catch(&err)
+		defer t.mod.marisa.SetStackPointer(t.mod.marisa.StackPointer())
 		t.mod.marisa.XQuerySetStr(int32(q.ptr), int32(str), int32(uint32(len(s))))
 		return
 	}(); err != nil {
-		t.queryDone(q)
+		err = t.mod.fault(err)
+		t.queryDone(q, &err)
 		return nil, err
 	}
 	return q, nil
@@ -106,35 +117,58 @@ func (t *Trie) queryID(id uint32) (*query, error) {
 
 	if err := func() (err error) {
 		defer wexcept.Catch(&err)
+		defer t.mod.marisa.SetStackPointer(t.mod.marisa.StackPointer())
 		t.mod.marisa.XQuerySetID(int32(q.ptr), int32(id))
 		return
 	}(); err != nil {
-		t.queryDone(q)
+		err = t.mod.fault(err)
+		t.queryDone(q, &err)
 		return nil, err
 	}
 	return q, nil
 }
 
-func (t *Trie) queryDone(q *query) {
+// queryDone releases q. If it fails, the module is marked as unusable, and err
+// is set if it is nil.
+func (t *Trie) queryDone(q *query, err *error) {
 	if t.mod == nil || q == nil {
 		return
 	}
+	q.release(err)
+}
+
+// release frees q, or caches it for reuse by the dictionary if it is still
+// loaded. If it fails, the module is marked as unusable, and err is set if it
+// is nil.
+func (q *query) release(err *error) {
+	if q.mod.err != nil {
+		return // the memory has already been released, or the module is in an unknown state
+	}
+	fail := func(e error) {
+		e = q.mod.fault(e)
+		if *err == nil {
+			*err = e
+		}
+	}
 	if q.ptr == 0 {
-		panic("double-free of query")
+		fail(internalError("double-free of query"))
+		return
 	}
 	if err := func() (err error) {
 		defer wexcept.Catch(&err)
-		t.mod.marisa.XQueryClear(int32(q.ptr))
+		defer q.mod.marisa.SetStackPointer(q.mod.marisa.StackPointer())
+		q.mod.marisa.XQueryClear(int32(q.ptr))
 		return
 	}(); err != nil {
-		panic(fmt.Errorf("marisa: failed to free query: %w", err))
+		fail(errorKind(ErrInternal, fmt.Errorf("failed to free query: %w", err)))
+		return
 	}
 	if q.longStr != 0 {
 		q.mod.Free(q.longStr)
 		q.longStr = 0
 	}
-	if !internal.NoCacheQuery && t.qry == nil {
-		t.qry = q
+	if !internal.NoCacheQuery && q.h.qry == nil && q.h.ptr != 0 {
+		q.h.qry = q
 		return
 	}
 	if q.shortStr != 0 {
@@ -143,40 +177,52 @@ func (t *Trie) queryDone(q *query) {
 	}
 	if err := func() (err error) {
 		defer wexcept.Catch(&err)
-		t.mod.marisa.XQueryFree(int32(q.ptr))
+		defer q.mod.marisa.SetStackPointer(q.mod.marisa.StackPointer())
+		q.mod.marisa.XQueryFree(int32(q.ptr))
 		return
 	}(); err != nil {
-		panic(fmt.Errorf("marisa: failed to free query: %w", err))
+		fail(errorKind(ErrInternal, fmt.Errorf("failed to free query: %w", err)))
+		return
 	}
 	q.ptr = 0
 }
 
 // Next gets the next result for a query, returning true if a result is
 // available. If q is nil, this always returns false.
-func (q *query) Next(fn func(*marisa_wasm.Module, int32) int32) (bool, error) {
+func (q *query) Next(fn func(*marisa_wasm.Module, int32, int32) int32) (bool, error) {
 	if q == nil {
 		return false, nil
 	}
+	if q.mod.err != nil {
+		return false, q.mod.err
+	}
+	if q.h.ptr == 0 {
+		return false, errorKind(ErrClosed, nil)
+	}
 
 	var ok bool
 	if res, err := func() (res int32, err error) {
 		defer wexcept.Catch(&err)
-		res = fn(q.mod.marisa, int32(q.ptr))
+		defer q.mod.marisa.SetStackPointer(q.mod.marisa.StackPointer())
+		defer q.mod.trap(&err)
+		res = fn(q.mod.marisa, int32(q.h.ptr), int32(q.ptr))
 		return
 	}(); err != nil {
-		return false, err
+		return false, q.mod.fault(err)
 	} else {
 		ok = res != 0
 	}
 	if ok {
 		res, err := func() (res [3]uint32, err error) {
 			defer wexcept.Catch(&err)
+			defer q.mod.marisa.SetStackPointer(q.mod.marisa.StackPointer())
+			defer q.mod.trap(&err)
 			r0, r1, r2 := q.mod.marisa.XQueryResult(int32(q.ptr))
 			res = [3]uint32{uint32(r0), uint32(r1), uint32(r2)}
 			return
 		}()
 		if err != nil {
-			return false, err
+			return false, q.mod.fault(err)
 		}
 		q.res = res
 	}
@@ -188,24 +234,26 @@ func (q *query) ID() uint32 {
 	return uint32(q.res[0])
 }
 
-// Key returns the key. It must only be called after Next returns true.
-func (q *query) Key() string {
+// Key returns the key. It must only be called after Next returns true. If the
+// result points outside module memory (i.e., the dictionary is corrupt), an
+// error is returned.
+func (q *query) Key() (string, error) {
 	b, ok := wmem.Bytes(q.mod.mem, q.res[1], q.res[2])
 	if !ok {
-		panic("bad pointer")
+		return "", corrupt(-1, "key points outside of memory", nil)
 	}
-	return string(b)
+	return string(b), nil
 }
 
 // Lookup checks whether a key is registered or not, returning its ID.
-func (t *Trie) Lookup(key string) (uint32, bool, error) {
+func (t *Trie) Lookup(key string) (_ uint32, _ bool, err error) {
 	q, err := t.queryString(key)
 	if err != nil {
 		return 0, false, err
 	}
-	defer t.queryDone(q)
+	defer t.queryDone(q, &err)
 
-	ok, err := q.Next((*marisa_wasm.Module).XQueryLookup)
+	ok, err := q.Next((*marisa_wasm.Module).XTrieLookup)
 	if err != nil {
 		return 0, false, err
 	}
@@ -216,7 +264,7 @@ func (t *Trie) Lookup(key string) (uint32, bool, error) {
 }
 
 // ReverseLookup gets a key by its ID.
-func (t *Trie) ReverseLookup(id uint32) (string, bool, error) {
+func (t *Trie) ReverseLookup(id uint32) (_ string, _ bool, err error) {
 	if id >= t.size {
 		return "", false, nil // optimization
 	}
@@ -225,16 +273,20 @@ func (t *Trie) ReverseLookup(id uint32) (string, bool, error) {
 	if err != nil {
 		return "", false, err
 	}
-	defer t.queryDone(q)
+	defer t.queryDone(q, &err)
 
-	ok, err := q.Next((*marisa_wasm.Module).XQueryReverseLookup)
+	ok, err := q.Next((*marisa_wasm.Module).XTrieReverseLookup)
 	if err != nil {
 		return "", false, err
 	}
 	if !ok {
 		return "", false, nil
 	}
-	return q.Key(), true, nil
+	key, err := q.Key()
+	if err != nil {
+		return "", false, err
+	}
+	return key, true, nil
 }
 
 // Dump dumps all keys. If the limit is -1, all keys are returned.
@@ -245,13 +297,13 @@ func (t *Trie) Dump(limit int) ([]Key, error) {
 // PredictiveSearch returns keys starting with a query string. If the limit is
 // -1, all keys are returned.
 func (t *Trie) PredictiveSearch(query string, limit int) ([]Key, error) {
-	return collectKeys(limit, t.search((*marisa_wasm.Module).XQueryPredictiveSearch, query))
+	return collectKeys(limit, t.search((*marisa_wasm.Module).XTriePredictiveSearch, query))
 }
 
 // CommonPrefixSearchSeq returns keys which equal any prefix of the query
 // string. If the limit is -1, all keys are returned.
 func (t *Trie) CommonPrefixSearch(query string, limit int) ([]Key, error) {
-	return collectKeys(limit, t.search((*marisa_wasm.Module).XQueryCommonPrefixSearch, query))
+	return collectKeys(limit, t.search((*marisa_wasm.Module).XTrieCommonPrefixSearch, query))
 }
 
 type Key struct {
@@ -277,24 +329,24 @@ func collectKeys(limit int, seq func(*error) iter.Seq2[uint32, string]) (res []K
 
 // DumpSeq dumps all keys.
 func (t *Trie) DumpSeq() func(*error) iter.Seq2[uint32, string] {
-	return t.search((*marisa_wasm.Module).XQueryPredictiveSearch, "")
+	return t.search((*marisa_wasm.Module).XTriePredictiveSearch, "")
 }
 
 // PredictiveSearch returns keys starting with a query string.
 func (t *Trie) PredictiveSearchSeq(query string) func(*error) iter.Seq2[uint32, string] {
-	return t.search((*marisa_wasm.Module).XQueryPredictiveSearch, query)
+	return t.search((*marisa_wasm.Module).XTriePredictiveSearch, query)
 }
 
 // CommonPrefixSearchSeq returns keys which equal any prefix of the query string.
 func (t *Trie) CommonPrefixSearchSeq(query string) func(*error) iter.Seq2[uint32, string] {
-	return t.search((*marisa_wasm.Module).XQueryCommonPrefixSearch, query)
+	return t.search((*marisa_wasm.Module).XTrieCommonPrefixSearch, query)
 }
 
 // search iterates over results for the specified query function.
-func (t *Trie) search(fn func(*marisa_wasm.Module, int32) int32, query string) func(*error) iter.Seq2[uint32, string] {
+func (t *Trie) search(fn func(*marisa_wasm.Module, int32, int32) int32, query string) func(*error) iter.Seq2[uint32, string] {
 	return func(err *error) iter.Seq2[uint32, string] {
 		return func(yield func(uint32, string) bool) {
-			*err = func() error {
+			*err = func() (err error) {
 				if t.mod == nil {
 					return nil
 				}
@@ -303,14 +355,21 @@ func (t *Trie) search(fn func(*marisa_wasm.Module, int32) int32, query string) f
 				if err != nil {
 					return err
 				}
-				defer t.queryDone(q)
+				defer t.queryDone(q, &err)
 
 				for {
 					ok, err := q.Next(fn)
 					if err != nil {
 						return err
 					}
-					if !ok || !yield(q.ID(), q.Key()) {
+					if !ok {
+						return nil
+					}
+					key, err := q.Key()
+					if err != nil {
+						return err
+					}
+					if !yield(q.ID(), key) {
 						return nil
 					}
 				}
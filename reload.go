@@ -0,0 +1,224 @@
+package marisa
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReloaderOptions configures a [Reloader].
+type ReloaderOptions struct {
+	// Interval is how often to check the file for changes. If zero, the
+	// dictionary is only reloaded when [Reloader.Reload] is called.
+	Interval time.Duration
+
+	// Check validates a new dictionary before it replaces the current one. If
+	// nil, only the header and section sizes are checked, which only reads
+	// the size of each section of a mapped file rather than its contents. To
+	// check the whole dictionary (which reads all of it), use [Trie.Verify].
+	Check func(*Trie) error
+
+	// OnError is called with errors from reloads triggered by polling. If nil,
+	// they are ignored, and the current dictionary continues to be used.
+	OnError func(error)
+}
+
+// Reloader is a handle to a dictionary file which can be safely replaced while
+// it is being used. When the file changes, the new dictionary is loaded and
+// validated, then swapped in for new calls to [Reloader.Do]. The old one is
+// closed once the calls which were using it return.
+//
+// It is safe for concurrent use. Since a [Trie] must not be used concurrently,
+// calls to [Reloader.Do] using the same dictionary are serialized.
+//
+// The file should be replaced atomically (i.e., by renaming a new file over
+// it) rather than being written in-place, since it may be memory-mapped.
+type Reloader struct {
+	name string
+	opt  ReloaderOptions
+	cur  atomic.Pointer[reloaderTrie]
+
+	mu     sync.Mutex // serializes reloads
+	fi     os.FileInfo
+	closed bool
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// reloaderTrie is a reference-counted dictionary.
+type reloaderTrie struct {
+	trie *Trie
+	mu   sync.Mutex   // held while using trie
+	refs atomic.Int64 // includes one for being current
+}
+
+// acquire increments the reference count if the trie hasn't been released.
+func (r *reloaderTrie) acquire() bool {
+	for {
+		n := r.refs.Load()
+		if n == 0 {
+			return false
+		}
+		if r.refs.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// release decrements the reference count, closing the trie when it reaches
+// zero.
+func (r *reloaderTrie) release() {
+	if r.refs.Add(-1) == 0 {
+		r.trie.Close()
+	}
+}
+
+// NewReloader opens the dictionary at name. If it cannot be loaded or fails
+// validation, an error is returned.
+func NewReloader(name string, opt ReloaderOptions) (*Reloader, error) {
+	r := &Reloader{
+		name: name,
+		opt:  opt,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	if r.opt.Interval > 0 {
+		r.stop = make(chan struct{})
+		r.done = make(chan struct{})
+		go r.poll()
+	}
+	return r, nil
+}
+
+// Do calls fn with the current dictionary. The dictionary must not be retained
+// after fn returns. If the reloader is closed, an error matching [ErrClosed] is
+// returned.
+func (r *Reloader) Do(fn func(*Trie) error) error {
+	for {
+		cur := r.cur.Load()
+		if cur == nil {
+			return errorKind(ErrClosed, nil)
+		}
+		if !cur.acquire() {
+			continue // it was replaced while we were acquiring it
+		}
+		defer cur.release()
+
+		cur.mu.Lock()
+		defer cur.mu.Unlock()
+
+		return fn(cur.trie)
+	}
+}
+
+// Reload loads the dictionary again, replacing the current one if it loads and
+// passes validation. Otherwise, the error is returned, and the current one
+// continues to be used. If the reloader is closed, an error matching
+// [ErrClosed] is returned.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return errorKind(ErrClosed, nil)
+	}
+	return r.reload()
+}
+
+// reload reloads the dictionary. It must be called with mu held.
+func (r *Reloader) reload() error {
+	fi, err := os.Stat(r.name)
+	if err != nil {
+		return err
+	}
+
+	trie, err := Open(r.name)
+	if err != nil {
+		return err
+	}
+
+	check := r.opt.Check
+	if check == nil {
+		check = (*Trie).verifySizes
+	}
+	if err := check(trie); err != nil {
+		trie.Close()
+		return err
+	}
+
+	next := &reloaderTrie{trie: trie}
+	next.refs.Store(1)
+	if prev := r.cur.Swap(next); prev != nil {
+		prev.release()
+	}
+	r.fi = fi
+	return nil
+}
+
+// poll reloads the dictionary when the file changes until the reloader is
+// closed.
+func (r *Reloader) poll() {
+	defer close(r.done)
+
+	tk := time.NewTicker(r.opt.Interval)
+	defer tk.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-tk.C:
+		}
+		if err := r.reloadIfChanged(); err != nil && r.opt.OnError != nil {
+			r.opt.OnError(err)
+		}
+	}
+}
+
+// reloadIfChanged reloads the dictionary if the file has been modified or
+// replaced since it was last loaded.
+func (r *Reloader) reloadIfChanged() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil
+	}
+
+	fi, err := os.Stat(r.name)
+	if err != nil {
+		return err
+	}
+	if os.SameFile(fi, r.fi) && fi.Size() == r.fi.Size() && fi.ModTime().Equal(r.fi.ModTime()) {
+		return nil
+	}
+	if err := r.reload(); err != nil {
+		r.fi = fi // don't retry until it changes again
+		return err
+	}
+	return nil
+}
+
+// Close stops polling, then closes the current dictionary once it is no longer
+// in use. Further calls to [Reloader.Do] will return an error matching
+// [ErrClosed].
+func (r *Reloader) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return errorKind(ErrClosed, nil)
+	}
+	r.closed = true
+	r.mu.Unlock()
+
+	if r.stop != nil {
+		close(r.stop)
+		<-r.done
+	}
+	if cur := r.cur.Swap(nil); cur != nil {
+		cur.release()
+	}
+	return nil
+}
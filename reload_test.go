@@ -0,0 +1,219 @@
+package marisa_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pgaskin/go-marisa"
+)
+
+func TestReloader(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dict.dat")
+
+	// note: files must be replaced atomically since mapped dictionaries would
+	// otherwise change underneath us
+
+	// write atomically replaces filename with buf
+	write := func(buf []byte) {
+		tmp := filename + ".tmp"
+		if err := os.WriteFile(tmp, buf, 0666); err != nil {
+			panic(err)
+		}
+		if err := os.Rename(tmp, filename); err != nil {
+			panic(err)
+		}
+	}
+
+	// replace atomically writes a dictionary containing keys to filename
+	replace := func(keys ...string) {
+		var trie marisa.Trie
+		if err := trie.Build(slices.Values(keys), marisa.Config{}); err != nil {
+			panic(err)
+		}
+		buf, err := trie.MarshalBinary()
+		if err != nil {
+			panic(err)
+		}
+		write(buf)
+	}
+
+	// has checks whether the current dictionary contains key
+	has := func(t *testing.T, r *marisa.Reloader, key string) bool {
+		t.Helper()
+		var ok bool
+		if err := r.Do(func(trie *marisa.Trie) (err error) {
+			_, ok, err = trie.Lookup(key)
+			return
+		}); err != nil {
+			t.Fatalf("lookup: %v", err)
+		}
+		return ok
+	}
+
+	t.Run("Missing", func(t *testing.T) {
+		if _, err := marisa.NewReloader(filepath.Join(t.TempDir(), "missing.dat"), marisa.ReloaderOptions{}); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected not exist error, got %v", err)
+		}
+	})
+
+	t.Run("Trigger", func(t *testing.T) {
+		replace("a", "b")
+
+		r, err := marisa.NewReloader(filename, marisa.ReloaderOptions{})
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		defer r.Close()
+
+		if !has(t, r, "a") || has(t, r, "c") {
+			t.Fatalf("incorrect initial dictionary")
+		}
+
+		replace("c", "d")
+		if has(t, r, "c") {
+			t.Errorf("dictionary should not be reloaded until triggered")
+		}
+		if err := r.Reload(); err != nil {
+			t.Fatalf("reload: %v", err)
+		}
+		if has(t, r, "a") || !has(t, r, "c") {
+			t.Errorf("dictionary was not reloaded")
+		}
+
+		write([]byte("junk"))
+		if err := r.Reload(); !errors.Is(err, marisa.ErrCorrupt) {
+			t.Errorf("expected corrupt error, got %v", err)
+		}
+		if !has(t, r, "c") {
+			t.Errorf("previous dictionary should be kept on error")
+		}
+
+		replace("e")
+		rejected := errors.New("rejected")
+		r2, err := marisa.NewReloader(filename, marisa.ReloaderOptions{
+			Check: func(trie *marisa.Trie) error {
+				if trie.Size() < 2 {
+					return rejected
+				}
+				return nil
+			},
+		})
+		if !errors.Is(err, rejected) {
+			t.Errorf("expected check error, got %v", err)
+		}
+		if r2 != nil {
+			t.Errorf("expected reloader to be nil on error")
+		}
+	})
+
+	t.Run("InFlight", func(t *testing.T) {
+		replace("a", "b")
+
+		r, err := marisa.NewReloader(filename, marisa.ReloaderOptions{})
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		defer r.Close()
+
+		var (
+			old      *marisa.Trie
+			acquired = make(chan struct{})
+			reloaded = make(chan struct{})
+			wg       sync.WaitGroup
+		)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.Do(func(trie *marisa.Trie) error {
+				old = trie
+				close(acquired)
+				<-reloaded
+				if _, ok, err := trie.Lookup("a"); err != nil || !ok {
+					t.Errorf("in-flight dictionary should still be usable after reload (err: %v)", err)
+				}
+				return nil
+			})
+		}()
+
+		<-acquired
+		replace("c", "d")
+		if err := r.Reload(); err != nil {
+			t.Fatalf("reload: %v", err)
+		}
+		if !has(t, r, "c") {
+			t.Errorf("dictionary was not reloaded")
+		}
+		close(reloaded)
+		wg.Wait()
+
+		if _, _, err := old.Lookup("a"); !errors.Is(err, marisa.ErrClosed) {
+			t.Errorf("expected old dictionary to be closed once idle, got %v", err)
+		}
+	})
+
+	t.Run("Poll", func(t *testing.T) {
+		replace("a", "b")
+
+		errs := make(chan error, 16)
+		r, err := marisa.NewReloader(filename, marisa.ReloaderOptions{
+			Interval: time.Millisecond * 10,
+			OnError: func(err error) {
+				select {
+				case errs <- err:
+				default:
+				}
+			},
+		})
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		defer r.Close()
+
+		replace("c", "d")
+		for deadline := time.Now().Add(time.Second * 10); !has(t, r, "c"); {
+			if time.Now().After(deadline) {
+				t.Fatalf("dictionary was not reloaded")
+			}
+			time.Sleep(time.Millisecond * 5)
+		}
+
+		write([]byte("junk"))
+		select {
+		case err := <-errs:
+			if !errors.Is(err, marisa.ErrCorrupt) {
+				t.Errorf("expected corrupt error, got %v", err)
+			}
+		case <-time.After(time.Second * 10):
+			t.Fatalf("expected error callback")
+		}
+		if !has(t, r, "c") {
+			t.Errorf("previous dictionary should be kept on error")
+		}
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		replace("a", "b")
+
+		r, err := marisa.NewReloader(filename, marisa.ReloaderOptions{Interval: time.Millisecond})
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if err := r.Close(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if err := r.Do(func(*marisa.Trie) error { return nil }); !errors.Is(err, marisa.ErrClosed) {
+			t.Errorf("expected closed error, got %v", err)
+		}
+		if err := r.Reload(); !errors.Is(err, marisa.ErrClosed) {
+			t.Errorf("expected closed error, got %v", err)
+		}
+		if err := r.Close(); !errors.Is(err, marisa.ErrClosed) {
+			t.Errorf("expected closed error, got %v", err)
+		}
+	})
+}
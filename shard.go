@@ -0,0 +1,607 @@
+package marisa
+
+import (
+	"bufio"
+	"cmp"
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ShardRouting assigns keys to the shards of a [ShardedTrie]. It is stored in
+// the sharded dictionary, so it can't be an arbitrary function. The zero value
+// is invalid.
+type ShardRouting struct {
+	kind   shardRoutingKind
+	n      int
+	bounds []string
+}
+
+type shardRoutingKind uint32
+
+const (
+	shardHash shardRoutingKind = iota + 1
+	shardRange
+)
+
+// maxShards is the maximum number of shards. It is mostly an arbitrary sanity
+// check to prevent huge allocations when reading corrupt files.
+const maxShards = 1 << 16
+
+// maxShardBound is the maximum length of a range boundary.
+const maxShardBound = 1 << 20
+
+// HashShards distributes keys evenly between n shards by their 64-bit FNV-1a
+// hash. Prefix searches need to query every shard.
+func HashShards(n int) ShardRouting {
+	return ShardRouting{kind: shardHash, n: n}
+}
+
+// RangeShards splits keys into len(bounds)+1 shards by lexicographic byte
+// order. Shard i contains the keys greater than or equal to bounds[i-1] and
+// less than bounds[i]. The bounds must be strictly increasing. Prefix searches
+// only need to query the shards which the prefix overlaps.
+func RangeShards(bounds ...string) ShardRouting {
+	return ShardRouting{kind: shardRange, n: len(bounds) + 1, bounds: slices.Clone(bounds)}
+}
+
+// NumShards returns the number of shards.
+func (r ShardRouting) NumShards() int {
+	return r.n
+}
+
+// Shard returns the shard which key belongs to. The routing must be valid.
+func (r ShardRouting) Shard(key string) int {
+	switch r.kind {
+	case shardHash:
+		h := uint64(14695981039346656037)
+		for i := 0; i < len(key); i++ {
+			h ^= uint64(key[i])
+			h *= 1099511628211
+		}
+		return int(h % uint64(r.n))
+	case shardRange:
+		i, found := slices.BinarySearch(r.bounds, key)
+		if found {
+			i++
+		}
+		return i
+	}
+	return 0
+}
+
+func (r ShardRouting) String() string {
+	switch r.kind {
+	case shardHash:
+		return fmt.Sprintf("hash(%d)", r.n)
+	case shardRange:
+		return fmt.Sprintf("range%q", r.bounds)
+	}
+	return "ShardRouting(invalid)"
+}
+
+func (r ShardRouting) valid() bool {
+	switch r.kind {
+	case shardHash:
+		return r.n > 0 && r.n <= maxShards
+	case shardRange:
+		if r.n != len(r.bounds)+1 || r.n > maxShards {
+			return false
+		}
+		for i, b := range r.bounds {
+			if len(b) > maxShardBound || (i != 0 && r.bounds[i-1] >= b) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// prefixShards returns the range of shards which may contain keys starting
+// with prefix.
+func (r ShardRouting) prefixShards(prefix string) (start, end int) {
+	if r.kind != shardRange || prefix == "" {
+		return 0, r.n
+	}
+	// keys with the prefix are in [prefix, next prefix), so any later shard
+	// containing them must start with the prefix
+	start = r.Shard(prefix)
+	end = start + 1
+	for end < r.n && strings.HasPrefix(r.bounds[end-1], prefix) {
+		end++
+	}
+	return start, end
+}
+
+// ShardedTrie is a dictionary split into multiple independent tries by a
+// [ShardRouting], allowing it to exceed the size limits of a single [Trie].
+// Keys have a global 64-bit ID, which is the number of keys in the preceding
+// shards plus the ID within the shard.
+//
+// A ShardedTrie must not be used concurrently.
+type ShardedTrie struct {
+	noCopy  noCopy
+	routing ShardRouting
+	shards  []*Trie
+	offsets []uint64 // the first ID of each shard, plus the total size
+}
+
+// BuildSharded builds a sharded dictionary, routing each key to a [Builder] for
+// its shard, then building the shards in parallel (up to GOMAXPROCS at a
+// time). If ctx is cancelled, the build is stopped, and the context error is
+// returned. While adding keys, ctx is checked periodically. If the routing or cfg
+// is invalid, an error matching [ErrInvalidConfig] is returned. If a key is
+// specified multiple times, the weights are accumulated.
+func BuildSharded(ctx context.Context, keys iter.Seq2[string, float32], routing ShardRouting, cfg Config) (*ShardedTrie, error) {
+	if !routing.valid() {
+		return nil, errorKind(ErrInvalidConfig, errors.New("invalid shard routing"))
+	}
+	if _, ok := configFlags(cfg); !ok {
+		return nil, errorKind(ErrInvalidConfig, nil)
+	}
+
+	builders := make([]*Builder, routing.NumShards())
+	defer func() {
+		for _, b := range builders {
+			if b != nil {
+				b.Close()
+			}
+		}
+	}()
+	for i := range builders {
+		b, err := NewBuilder(BuilderOptions{})
+		if err != nil {
+			return nil, err
+		}
+		builders[i] = b
+	}
+	var n int
+	for key, weight := range keys {
+		if n%buildProgressInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		if err := builders[routing.Shard(key)].Add(key, weight); err != nil {
+			return nil, err
+		}
+		n++
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var (
+		wg     sync.WaitGroup
+		sem    = make(chan struct{}, runtime.GOMAXPROCS(0))
+		shards = make([]*Trie, len(builders))
+		errs   = make([]error, len(builders))
+	)
+	for i, b := range builders {
+		sem <- struct{}{} // each one needs memory for the build and the loaded shard
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			shards[i], errs[i] = b.Finish(ctx, cfg)
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		for _, t := range shards {
+			if t != nil {
+				t.Close()
+			}
+		}
+		return nil, err
+	}
+	return newShardedTrie(routing, shards), nil
+}
+
+func newShardedTrie(routing ShardRouting, shards []*Trie) *ShardedTrie {
+	offsets := make([]uint64, len(shards)+1)
+	for i, t := range shards {
+		offsets[i+1] = offsets[i] + uint64(t.Size())
+	}
+	return &ShardedTrie{
+		routing: routing,
+		shards:  shards,
+		offsets: offsets,
+	}
+}
+
+// Routing returns the routing used to assign keys to shards.
+func (s *ShardedTrie) Routing() ShardRouting {
+	return s.routing
+}
+
+// NumShards returns the number of shards.
+func (s *ShardedTrie) NumShards() int {
+	return len(s.shards)
+}
+
+// Shard returns the trie for shard i, and the global ID of its first key. It
+// is owned by s, and must not be closed or reloaded.
+func (s *ShardedTrie) Shard(i int) (*Trie, uint64) {
+	return s.shards[i], s.offsets[i]
+}
+
+// Size returns the total number of keys.
+func (s *ShardedTrie) Size() uint64 {
+	return s.offsets[len(s.shards)]
+}
+
+// DiskSize returns the size of the serialized sharded dictionary.
+func (s *ShardedTrie) DiskSize() uint64 {
+	n := uint64(len(s.appendHeader(nil)))
+	for _, t := range s.shards {
+		n += uint64(t.DiskSize())
+	}
+	return n
+}
+
+// Close closes all shards.
+func (s *ShardedTrie) Close() error {
+	var errs []error
+	for _, t := range s.shards {
+		errs = append(errs, t.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Lookup checks whether a key is registered or not, returning its global ID.
+func (s *ShardedTrie) Lookup(key string) (uint64, bool, error) {
+	i := s.routing.Shard(key)
+	id, ok, err := s.shards[i].Lookup(key)
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	return s.offsets[i] + uint64(id), true, nil
+}
+
+// ReverseLookup gets a key by its global ID.
+func (s *ShardedTrie) ReverseLookup(id uint64) (string, bool, error) {
+	if id >= s.Size() {
+		return "", false, nil
+	}
+	i, found := slices.BinarySearch(s.offsets, id)
+	if !found {
+		i--
+	}
+	for s.offsets[i+1] == id {
+		i++ // skip empty shards
+	}
+	return s.shards[i].ReverseLookup(uint32(id - s.offsets[i]))
+}
+
+// DumpSeq dumps all keys, in the same order as
+// [ShardedTrie.PredictiveSearchSeq].
+func (s *ShardedTrie) DumpSeq() func(*error) iter.Seq2[uint64, string] {
+	return s.PredictiveSearchSeq("")
+}
+
+// PredictiveSearchSeq returns keys starting with a query string. If the shards
+// use [LabelOrder], the results are in lexicographic order like for a single
+// [Trie] (with [HashShards], this requires merging the results from every
+// shard). Otherwise, they are returned one shard at a time, so they are only in
+// the same order as for a single [Trie] if a single shard is searched.
+func (s *ShardedTrie) PredictiveSearchSeq(query string) func(*error) iter.Seq2[uint64, string] {
+	return func(err *error) iter.Seq2[uint64, string] {
+		return func(yield func(uint64, string) bool) {
+			*err = nil
+			start, end := s.routing.prefixShards(query)
+			if end-start > 1 && s.routing.kind == shardHash && s.shards[start].NodeOrder() == LabelOrder {
+				*err = s.mergePredictiveSearch(query, start, end, yield)
+				return
+			}
+			for i := start; i < end; i++ {
+				for id, key := range s.shards[i].PredictiveSearchSeq(query)(err) {
+					if !yield(s.offsets[i]+uint64(id), key) {
+						return
+					}
+				}
+				if *err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// mergePredictiveSearch merges the predictive search results from the
+// specified shards, which must be in lexicographic order, by key.
+func (s *ShardedTrie) mergePredictiveSearch(query string, start, end int, yield func(uint64, string) bool) error {
+	var (
+		all = make([]*shardCursor, 0, end-start)
+		h   = make(shardCursors, 0, end-start)
+	)
+	defer func() {
+		for _, c := range all {
+			c.stop()
+		}
+	}()
+	for i := start; i < end; i++ {
+		c := &shardCursor{offset: s.offsets[i], err: new(error)}
+		c.next, c.stop = iter.Pull2(s.shards[i].PredictiveSearchSeq(query)(c.err))
+		all = append(all, c)
+		if ok, err := c.advance(); err != nil {
+			return err
+		} else if ok {
+			h = append(h, c)
+		}
+	}
+	heap.Init(&h)
+	for len(h) != 0 {
+		c := h[0]
+		if !yield(c.id, c.key) {
+			return nil
+		}
+		if ok, err := c.advance(); err != nil {
+			return err
+		} else if ok {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+	return nil
+}
+
+// shardCursor is the current result of a search in a shard.
+type shardCursor struct {
+	offset uint64
+	id     uint64
+	key    string
+	err    *error
+	next   func() (uint32, string, bool)
+	stop   func()
+}
+
+// advance gets the next result, returning false if there are no more.
+func (c *shardCursor) advance() (bool, error) {
+	id, key, ok := c.next()
+	if !ok {
+		c.stop()
+		return false, *c.err
+	}
+	c.id, c.key = c.offset+uint64(id), key
+	return true, nil
+}
+
+// shardCursors is a min-heap of cursors by key.
+type shardCursors []*shardCursor
+
+func (h shardCursors) Len() int           { return len(h) }
+func (h shardCursors) Less(i, j int) bool { return h[i].key < h[j].key }
+func (h shardCursors) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *shardCursors) Push(x any)        { *h = append(*h, x.(*shardCursor)) }
+func (h *shardCursors) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// CommonPrefixSearchSeq returns keys which equal any prefix of the query
+// string, shortest first.
+func (s *ShardedTrie) CommonPrefixSearchSeq(query string) func(*error) iter.Seq2[uint64, string] {
+	return func(err *error) iter.Seq2[uint64, string] {
+		return func(yield func(uint64, string) bool) {
+			*err = nil
+
+			// every prefix is routed to a single shard, so only search those
+			var shards []int
+			for n := 0; n <= len(query); n++ {
+				if i := s.routing.Shard(query[:n]); !slices.Contains(shards, i) {
+					shards = append(shards, i)
+				}
+			}
+			if len(shards) == 1 {
+				i := shards[0]
+				for id, key := range s.shards[i].CommonPrefixSearchSeq(query)(err) {
+					if !yield(s.offsets[i]+uint64(id), key) {
+						return
+					}
+				}
+				return
+			}
+
+			// there's at most one result for each prefix length
+			var res []ShardedKey
+			for _, i := range shards {
+				for id, key := range s.shards[i].CommonPrefixSearchSeq(query)(err) {
+					res = append(res, ShardedKey{s.offsets[i] + uint64(id), key})
+				}
+				if *err != nil {
+					return
+				}
+			}
+			slices.SortFunc(res, func(a, b ShardedKey) int {
+				return cmp.Compare(len(a.Key), len(b.Key))
+			})
+			for _, k := range res {
+				if !yield(k.ID, k.Key) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// ShardedKey is a key in a [ShardedTrie].
+type ShardedKey struct {
+	ID  uint64
+	Key string
+}
+
+// Sharded dictionary format (little-endian):
+//
+//	magic      [16]byte "go-marisa/shard\x00"
+//	routing    uint32   kind (1 = hash, 2 = range)
+//	numShards  uint32
+//	numBounds  uint32   (range only)
+//	bounds     [numBounds]{len uint32; [len]byte}
+//	sizes      [numShards]uint64
+//	shards     [numShards]dictionary
+const shardMagic = "go-marisa/shard\x00"
+
+func (s *ShardedTrie) appendHeader(b []byte) []byte {
+	b = append(b, shardMagic...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(s.routing.kind))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(s.shards)))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(s.routing.bounds)))
+	for _, x := range s.routing.bounds {
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(x)))
+		b = append(b, x...)
+	}
+	for _, t := range s.shards {
+		b = binary.LittleEndian.AppendUint64(b, uint64(t.DiskSize()))
+	}
+	return b
+}
+
+// WriteTo writes all shards as a single sharded dictionary.
+func (s *ShardedTrie) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(s.appendHeader(nil))
+	if err != nil {
+		return int64(n), err
+	}
+	total := int64(n)
+	for _, t := range s.shards {
+		n, err := t.WriteTo(w)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// readShardHeader reads the routing and shard sizes from a sharded dictionary.
+func readShardHeader(r io.Reader) (routing ShardRouting, sizes []uint64, n int64, err error) {
+	c := &countReader{R: r}
+	defer func() {
+		n = c.N
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = corrupt(n, "truncated sharded dictionary header", io.ErrUnexpectedEOF)
+		}
+	}()
+	var hdr struct {
+		Magic     [16]byte
+		Kind      uint32
+		NumShards uint32
+		NumBounds uint32
+	}
+	if err := binary.Read(c, binary.LittleEndian, &hdr); err != nil {
+		return routing, nil, 0, err
+	}
+	if string(hdr.Magic[:]) != shardMagic {
+		return routing, nil, 0, corrupt(0, "not a sharded dictionary", nil)
+	}
+	if hdr.NumShards > maxShards || hdr.NumBounds > maxShards {
+		return routing, nil, 0, corrupt(16, "too many shards", nil)
+	}
+	routing = ShardRouting{kind: shardRoutingKind(hdr.Kind), n: int(hdr.NumShards)}
+	if hdr.NumBounds != 0 {
+		routing.bounds = make([]string, hdr.NumBounds)
+		for i := range routing.bounds {
+			var sz uint32
+			if err := binary.Read(c, binary.LittleEndian, &sz); err != nil {
+				return routing, nil, 0, err
+			}
+			if sz > maxShardBound {
+				return routing, nil, 0, corrupt(c.N-4, "shard boundary too long", nil)
+			}
+			buf := make([]byte, sz)
+			if _, err := io.ReadFull(c, buf); err != nil {
+				return routing, nil, 0, err
+			}
+			routing.bounds[i] = string(buf)
+		}
+	}
+	if !routing.valid() {
+		return routing, nil, 0, corrupt(16, "invalid shard routing", nil)
+	}
+	sizes = make([]uint64, hdr.NumShards)
+	if err := binary.Read(c, binary.LittleEndian, sizes); err != nil {
+		return routing, nil, 0, err
+	}
+	for i, sz := range sizes {
+		if sz > maxAlloc {
+			return routing, nil, 0, corrupt(c.N-int64(len(sizes)-i)*8, "shard too large", errorKind(ErrTooLarge, nil))
+		}
+	}
+	return routing, sizes, 0, nil
+}
+
+// LoadSharded reads a sharded dictionary from r.
+func LoadSharded(r io.Reader) (*ShardedTrie, error) {
+	br := bufio.NewReader(r)
+	routing, sizes, off, err := readShardHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	shards := make([]*Trie, 0, len(sizes))
+	for _, size := range sizes {
+		var t Trie
+		n, err := t.readFrom(io.LimitReader(br, int64(size)), int64(size))
+		if err == nil && uint64(n) != size {
+			t.Close()
+			err = corrupt(off+n, "shard size mismatch", nil)
+		}
+		if err != nil {
+			for _, t := range shards {
+				t.Close()
+			}
+			return nil, err
+		}
+		shards = append(shards, &t)
+		off += n
+	}
+	return newShardedTrie(routing, shards), nil
+}
+
+// OpenSharded opens a sharded dictionary from a file. Each shard is mapped if
+// well-supported on the current platform, and read otherwise.
+func OpenSharded(name string) (*ShardedTrie, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	routing, sizes, off, err := readShardHeader(bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+	shards := make([]*Trie, 0, len(sizes))
+	for _, size := range sizes {
+		t, err := openSection(f, off, int64(size))
+		if err != nil {
+			for _, t := range shards {
+				t.Close()
+			}
+			return nil, err
+		}
+		if uint64(t.DiskSize()) != size {
+			t.Close()
+			for _, t := range shards {
+				t.Close()
+			}
+			return nil, corrupt(off, "shard size mismatch", nil)
+		}
+		shards = append(shards, t)
+		off += int64(size)
+	}
+	return newShardedTrie(routing, shards), nil
+}
@@ -0,0 +1,281 @@
+package marisa_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"iter"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pgaskin/go-marisa"
+	"github.com/pgaskin/go-marisa/testdata"
+)
+
+func TestShardedTrie(t *testing.T) {
+	var keys []string
+	for i, word := range testdata.Words {
+		if i%5 == 0 {
+			keys = append(keys, word)
+		}
+	}
+	var trie marisa.Trie
+	if err := trie.Build(slices.Values(keys), marisa.Config{}); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer trie.Close()
+
+	words := func(yield func(string, float32) bool) {
+		for _, key := range keys {
+			if !yield(key, 1) {
+				return
+			}
+		}
+	}
+	for _, routing := range []marisa.ShardRouting{
+		marisa.HashShards(1),
+		marisa.HashShards(7),
+		marisa.RangeShards("c", "h", "ha", "i", "s", "zzz"),
+		marisa.RangeShards("a"), // empty first shard
+	} {
+		t.Run(routing.String(), func(t *testing.T) {
+			s, err := marisa.BuildSharded(context.Background(), words, routing, marisa.Config{})
+			if err != nil {
+				t.Fatalf("error: %v", err)
+			}
+			defer s.Close()
+			checkShardedTrie(t, s, &trie, keys)
+
+			var buf bytes.Buffer
+			if n, err := s.WriteTo(&buf); err != nil {
+				t.Fatalf("write: %v", err)
+			} else if uint64(n) != s.DiskSize() || n != int64(buf.Len()) {
+				t.Errorf("expected %d bytes to be written, got %d", s.DiskSize(), n)
+			}
+
+			t.Run("Load", func(t *testing.T) {
+				s, err := marisa.LoadSharded(bytes.NewReader(buf.Bytes()))
+				if err != nil {
+					t.Fatalf("error: %v", err)
+				}
+				defer s.Close()
+				if s.Routing().String() != routing.String() {
+					t.Errorf("expected routing %s, got %s", routing, s.Routing())
+				}
+				checkShardedTrie(t, s, &trie, keys)
+			})
+			t.Run("Open", func(t *testing.T) {
+				name := filepath.Join(t.TempDir(), "words.shards")
+				if err := os.WriteFile(name, buf.Bytes(), 0666); err != nil {
+					t.Fatal(err)
+				}
+				s, err := marisa.OpenSharded(name)
+				if err != nil {
+					t.Fatalf("error: %v", err)
+				}
+				defer s.Close()
+				checkShardedTrie(t, s, &trie, keys)
+			})
+			t.Run("Corrupt", func(t *testing.T) {
+				b := buf.Bytes()
+				for _, n := range []int{0, 10, 30, len(b) / 2, len(b) - 1} {
+					if _, err := marisa.LoadSharded(bytes.NewReader(b[:n])); !errors.Is(err, marisa.ErrCorrupt) || !errors.Is(err, io.ErrUnexpectedEOF) {
+						t.Errorf("truncated to %d: expected corrupt error, got %v", n, err)
+					}
+				}
+				if _, err := marisa.LoadSharded(strings.NewReader(strings.Repeat("x", 64))); !errors.Is(err, marisa.ErrCorrupt) {
+					t.Errorf("expected corrupt error, got %v", err)
+				}
+			})
+		})
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, routing := range []marisa.ShardRouting{
+			{},
+			marisa.HashShards(0),
+			marisa.RangeShards("b", "a"),
+			marisa.RangeShards("a", "a"),
+		} {
+			if _, err := marisa.BuildSharded(context.Background(), words, routing, marisa.Config{}); !errors.Is(err, marisa.ErrInvalidConfig) {
+				t.Errorf("%s: expected invalid config error, got %v", routing, err)
+			}
+		}
+		if _, err := marisa.BuildSharded(context.Background(), words, marisa.HashShards(2), marisa.Config{NumTries: -1}); !errors.Is(err, marisa.ErrInvalidConfig) {
+			t.Errorf("expected invalid config error, got %v", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := marisa.BuildSharded(ctx, words, marisa.HashShards(2), marisa.Config{}); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context error, got %v", err)
+		}
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var n int
+		many := func(yield func(string, float32) bool) {
+			for n = 0; n < 1<<20; n++ {
+				if n == 1000 {
+					cancel()
+				}
+				if !yield(strconv.Itoa(n), 1) {
+					return
+				}
+			}
+		}
+		if _, err := marisa.BuildSharded(ctx, many, marisa.HashShards(2), marisa.Config{}); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context error, got %v", err)
+		}
+		if n >= 1<<20 {
+			t.Errorf("expected adding keys to stop after cancellation")
+		}
+	})
+
+	t.Run("LabelOrder", func(t *testing.T) {
+		// results from hashed shards must be merged to match a single trie
+		cfg := marisa.Config{NodeOrder: marisa.LabelOrder}
+		var trie marisa.Trie
+		if err := trie.Build(slices.Values(keys), cfg); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		defer trie.Close()
+
+		s, err := marisa.BuildSharded(context.Background(), words, marisa.HashShards(7), cfg)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		defer s.Close()
+
+		for _, query := range []string{"", "a", "ha", "in", "zzzz"} {
+			var act, exp []string
+			for id, key := range s.PredictiveSearchSeq(query)(&err) {
+				if a, _, _ := s.ReverseLookup(id); a != key {
+					t.Errorf("id %d: expected %q, got %q", id, key, a)
+				}
+				act = append(act, key)
+			}
+			if err != nil {
+				t.Fatalf("error: %v", err)
+			}
+			for _, key := range trie.PredictiveSearchSeq(query)(&err) {
+				exp = append(exp, key)
+			}
+			if err != nil {
+				t.Fatalf("error: %v", err)
+			}
+			if !slices.Equal(act, exp) {
+				t.Errorf("predictive search %q: results not in the same order", query)
+			}
+		}
+
+		var n int
+		for range s.DumpSeq()(&err) {
+			if n++; n == 10 {
+				break
+			}
+		}
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if _, ok, err := s.Lookup(keys[0]); err != nil || !ok {
+			t.Errorf("expected shards to be usable after stopping a merged search, got %v", err)
+		}
+	})
+	t.Run("Close", func(t *testing.T) {
+		s, err := marisa.BuildSharded(context.Background(), words, marisa.HashShards(3), marisa.Config{})
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if _, _, err := s.Lookup("a"); !errors.Is(err, marisa.ErrClosed) {
+			t.Errorf("expected closed error, got %v", err)
+		}
+		if err := s.Close(); !errors.Is(err, marisa.ErrClosed) {
+			t.Errorf("expected closed error, got %v", err)
+		}
+	})
+}
+
+// checkShardedTrie checks that s contains exactly the keys, and that the
+// searches match the same keys as trie.
+func checkShardedTrie(t *testing.T, s *marisa.ShardedTrie, trie *marisa.Trie, keys []string) {
+	t.Helper()
+
+	if act := s.Size(); act != uint64(len(keys)) {
+		t.Fatalf("expected %d keys, got %d", len(keys), act)
+	}
+	seen := make([]bool, s.Size())
+	for _, word := range keys {
+		id, ok, err := s.Lookup(word)
+		if err != nil || !ok {
+			t.Fatalf("lookup %q: %v", word, err)
+		}
+		if seen[id] {
+			t.Fatalf("duplicate id %d", id)
+		}
+		seen[id] = true
+		if act, ok, err := s.ReverseLookup(id); err != nil || !ok || act != word {
+			t.Fatalf("reverse lookup %d: expected %q, got %q (%v)", id, word, act, err)
+		}
+	}
+	if _, ok, err := s.Lookup("notaword"); err != nil || ok {
+		t.Errorf("expected lookup of missing key to fail, got %v (%v)", ok, err)
+	}
+	if _, ok, err := s.ReverseLookup(s.Size()); err != nil || ok {
+		t.Errorf("expected reverse lookup past the end to fail, got %v (%v)", ok, err)
+	}
+
+	collect := func(seq func(*error) iter.Seq2[uint64, string]) []string {
+		t.Helper()
+		var res []string
+		var err error
+		for id, key := range seq(&err) {
+			if act, _, _ := s.ReverseLookup(id); act != key {
+				t.Errorf("id %d: expected %q, got %q", id, key, act)
+			}
+			res = append(res, key)
+		}
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		return res
+	}
+	expected := func(seq func(*error) iter.Seq2[uint32, string]) []string {
+		t.Helper()
+		var res []string
+		var err error
+		for _, key := range seq(&err) {
+			res = append(res, key)
+		}
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		return res
+	}
+	if act := collect(s.DumpSeq()); len(act) != len(keys) {
+		t.Errorf("expected %d keys to be dumped, got %d", len(keys), len(act))
+	}
+	for _, query := range []string{"", "a", "ha", "hat", "in", "z", "zzzz", "\xff"} {
+		act, exp := collect(s.PredictiveSearchSeq(query)), expected(trie.PredictiveSearchSeq(query))
+		slices.Sort(act) // only in the same order within a shard for WeightOrder
+		slices.Sort(exp)
+		if !slices.Equal(act, exp) {
+			t.Errorf("predictive search %q: expected %d keys, got %d", query, len(exp), len(act))
+		}
+	}
+	for _, query := range []string{"", "a", "hatter", "information", "understandings", "zzzz"} {
+		act, exp := collect(s.CommonPrefixSearchSeq(query)), expected(trie.CommonPrefixSearchSeq(query))
+		if !slices.Equal(act, exp) {
+			t.Errorf("common prefix search %q: expected %q, got %q", query, exp, act)
+		}
+	}
+}
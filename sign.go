@@ -0,0 +1,101 @@
+package marisa
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"errors"
+	"io"
+	"os"
+)
+
+// SignatureSize is the size of a detached dictionary signature.
+const SignatureSize = ed25519.SignatureSize
+
+// signatureContext is the Ed25519ph context string for dictionary signatures,
+// which prevents them from being valid for anything else signed with the same
+// key.
+const signatureContext = "github.com/pgaskin/go-marisa dictionary"
+
+// ErrBadSignature is returned if a dictionary signature does not verify against
+// any of the provided public keys.
+var ErrBadSignature = errors.New("dictionary signature verification failed")
+
+// Sign creates a detached signature for the serialized dictionary read from r,
+// which is read until EOF. To sign a dictionary embedded in a larger file, use
+// an [io.SectionReader].
+//
+// The signature is Ed25519ph (i.e., it is over the SHA-512 hash of the data),
+// so the dictionary does not need to be held in memory.
+func Sign(key ed25519.PrivateKey, r io.Reader) ([]byte, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, errors.New("invalid private key")
+	}
+	h := sha512.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return key.Sign(rand.Reader, h.Sum(nil), signatureOptions())
+}
+
+// Verify checks a detached signature created by [Sign] for the serialized
+// dictionary read from r, which is read until EOF. If the signature does not
+// verify against any of the keys, [ErrBadSignature] is returned.
+func Verify(r io.Reader, sig []byte, keys ...ed25519.PublicKey) error {
+	h := sha512.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return err
+	}
+	return verifyDigest(h.Sum(nil), sig, keys)
+}
+
+// OpenVerified is like [Open], but refuses to load the dictionary unless sig is
+// a valid signature for the entire file by one of the keys.
+//
+// If the file is memory-mapped, the signature is checked against the mapped
+// data rather than by reading the file separately, so a file replaced between
+// verification and loading will not be accepted. Note that modifications made
+// to the underlying file while it is mapped will still be visible.
+func OpenVerified(name string, sig []byte, keys ...ed25519.PublicKey) (*Trie, error) {
+	t, _, err := open(name, OpenOptions{}, func(b []byte) error {
+		return verifyBytes(b, sig, keys)
+	})
+	return t, err
+}
+
+// MapFileVerified is like [Trie.MapFile], but refuses to load the dictionary
+// unless sig is a valid signature for the mapped range by one of the keys. On
+// error, the trie is left unchanged.
+func (t *Trie) MapFileVerified(f *os.File, offset int64, length int64, sig []byte, keys ...ed25519.PublicKey) error {
+	return t.mapFile(f, offset, length, 0, func(b []byte) error {
+		return verifyBytes(b, sig, keys)
+	})
+}
+
+func verifyBytes(b, sig []byte, keys []ed25519.PublicKey) error {
+	digest := sha512.Sum512(b)
+	return verifyDigest(digest[:], sig, keys)
+}
+
+func verifyDigest(digest, sig []byte, keys []ed25519.PublicKey) error {
+	if len(sig) != SignatureSize {
+		return ErrBadSignature
+	}
+	for _, key := range keys {
+		if len(key) != ed25519.PublicKeySize {
+			continue
+		}
+		if ed25519.VerifyWithOptions(key, digest, sig, signatureOptions()) == nil {
+			return nil
+		}
+	}
+	return ErrBadSignature
+}
+
+func signatureOptions() *ed25519.Options {
+	return &ed25519.Options{
+		Hash:    crypto.SHA512,
+		Context: signatureContext,
+	}
+}
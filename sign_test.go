@@ -0,0 +1,135 @@
+package marisa_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/pgaskin/go-marisa"
+)
+
+func TestSign(t *testing.T) {
+	expected := mustWordsTrieData()
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		panic(err)
+	}
+	otherPub, otherPriv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		panic(err)
+	}
+
+	sig, err := marisa.Sign(priv, bytes.NewReader(expected))
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	} else if len(sig) != marisa.SignatureSize {
+		t.Fatalf("incorrect signature size %d", len(sig))
+	}
+
+	filename := filepath.Join(t.TempDir(), "words.dat")
+	if err := os.WriteFile(filename, expected, 0666); err != nil {
+		panic(err)
+	}
+
+	tampered := slices.Clone(expected)
+	tampered[len(tampered)/2] ^= 0xFF
+	tamperedFilename := filepath.Join(t.TempDir(), "tampered.dat")
+	if err := os.WriteFile(tamperedFilename, tampered, 0666); err != nil {
+		panic(err)
+	}
+
+	checkTrie := func(trie *marisa.Trie) bool {
+		buf, err := trie.MarshalBinary()
+		if err != nil {
+			return false
+		}
+		return bytes.Equal(buf, expected)
+	}
+
+	t.Run("Verify", func(t *testing.T) {
+		if err := marisa.Verify(bytes.NewReader(expected), sig, pub); err != nil {
+			t.Errorf("error: %v", err)
+		}
+		if err := marisa.Verify(bytes.NewReader(expected), sig, otherPub, pub); err != nil {
+			t.Errorf("should verify if any key matches (got %v)", err)
+		}
+		if err := marisa.Verify(bytes.NewReader(expected), sig, otherPub); !errors.Is(err, marisa.ErrBadSignature) {
+			t.Errorf("expected bad signature for wrong key (got %v)", err)
+		}
+		if err := marisa.Verify(bytes.NewReader(expected), sig); !errors.Is(err, marisa.ErrBadSignature) {
+			t.Errorf("expected bad signature for no keys (got %v)", err)
+		}
+		if err := marisa.Verify(bytes.NewReader(tampered), sig, pub); !errors.Is(err, marisa.ErrBadSignature) {
+			t.Errorf("expected bad signature for tampered data (got %v)", err)
+		}
+		if err := marisa.Verify(bytes.NewReader(expected), sig[:10], pub); !errors.Is(err, marisa.ErrBadSignature) {
+			t.Errorf("expected bad signature for truncated signature (got %v)", err)
+		}
+		if other, err := marisa.Sign(otherPriv, bytes.NewReader(expected)); err != nil {
+			t.Errorf("error: %v", err)
+		} else if err := marisa.Verify(bytes.NewReader(expected), other, pub); !errors.Is(err, marisa.ErrBadSignature) {
+			t.Errorf("expected bad signature for signature by another key (got %v)", err)
+		}
+		if ed25519.Verify(pub, expected, sig) {
+			t.Errorf("signature should not be a valid pure ed25519 signature")
+		}
+	})
+
+	t.Run("Embedded", func(t *testing.T) {
+		buf := slices.Concat(filled(byte(0xFF), 32), expected, filled(byte(0xFF), 32))
+		r := io.NewSectionReader(bytes.NewReader(buf), 32, int64(len(expected)))
+		if err := marisa.Verify(r, sig, pub); err != nil {
+			t.Errorf("error: %v", err)
+		}
+
+		filename := filepath.Join(t.TempDir(), "offset.dat")
+		if err := os.WriteFile(filename, buf, 0666); err != nil {
+			panic(err)
+		}
+
+		f, err := os.Open(filename)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+
+		var trie marisa.Trie
+		if err := trie.MapFileVerified(f, 32, int64(len(expected)), sig, otherPub); !errors.Is(err, marisa.ErrBadSignature) {
+			if errors.Is(err, errors.ErrUnsupported) {
+				t.Skipf("unsupported platform: %v", err)
+			}
+			t.Errorf("expected bad signature for wrong key (got %v)", err)
+		} else if trie.Size() != 0 {
+			t.Errorf("trie should be left unchanged on error")
+		}
+		if err := trie.MapFileVerified(f, 32, int64(len(expected)), sig, pub); err != nil {
+			t.Errorf("error: %v", err)
+		} else if !checkTrie(&trie) {
+			t.Errorf("round-trip failed")
+		}
+	})
+
+	t.Run("OpenVerified", func(t *testing.T) {
+		if trie, err := marisa.OpenVerified(filename, sig, pub); err != nil {
+			t.Errorf("error: %v", err)
+		} else if !checkTrie(trie) {
+			t.Errorf("round-trip failed")
+		}
+		if trie, err := marisa.OpenVerified(filename, sig, otherPub); !errors.Is(err, marisa.ErrBadSignature) {
+			t.Errorf("expected bad signature for wrong key (got %v)", err)
+		} else if trie != nil {
+			t.Errorf("expected trie to be nil on error")
+		}
+		if trie, err := marisa.OpenVerified(tamperedFilename, sig, pub); !errors.Is(err, marisa.ErrBadSignature) {
+			t.Errorf("expected bad signature for tampered file (got %v)", err)
+		} else if trie != nil {
+			t.Errorf("expected trie to be nil on error")
+		}
+	})
+}
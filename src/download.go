@@ -110,6 +110,104 @@ func patch(files map[string][]byte) error {
 			files[name] = src
 		}
 	}
+	{
+		slog.Info("patching marisa::Trie to support mapping in-place so a failed map doesn't leak the partially-mapped trie")
+		var n int
+		name := "include/marisa/trie.h"
+		files[name], n = bytesTryReplaceAll(files[name],
+			[]byte(`void map(const void *ptr, std::size_t size);`),
+			[]byte("void map(const void *ptr, std::size_t size);\n  void map_in_place(const void *ptr, std::size_t size);"))
+		if n != 1 {
+			return fmt.Errorf("failed to apply patch")
+		}
+		name = "lib/marisa/grimoire/trie/louds-trie.h"
+		files[name], n = bytesTryReplaceAll(files[name],
+			[]byte(`std::size_t io_size() const;`),
+			[]byte("std::size_t io_size() const;\n  void map_in_place(Mapper &mapper);"))
+		if n != 1 {
+			return fmt.Errorf("failed to apply patch")
+		}
+		files["lib/marisa/trie.cc"] = append(files["lib/marisa/trie.cc"], `
+namespace marisa {
+
+// like map, but the trie owns the new LoudsTrie while it is being mapped, so if
+// it throws, it is still freed with the trie (we can't unwind the stack)
+void Trie::map_in_place(const void *ptr, std::size_t size) {
+  if ((ptr == nullptr) && (size != 0)) {
+    throw std::invalid_argument("(ptr == nullptr) && (size != 0)");
+  }
+  trie_.reset(new grimoire::LoudsTrie);
+  grimoire::Mapper mapper;
+  mapper.open(ptr, size);
+  trie_->map_in_place(mapper);
+}
+
+}  // namespace marisa
+`...)
+		files["lib/marisa/grimoire/trie/louds-trie.cc"] = append(files["lib/marisa/grimoire/trie/louds-trie.cc"], `
+namespace marisa::grimoire::trie {
+
+// like map, but without a temporary LoudsTrie on the stack
+void LoudsTrie::map_in_place(Mapper &mapper) {
+  Header().map(mapper);
+  map_(mapper);
+}
+
+}  // namespace marisa::grimoire::trie
+`...)
+	}
+	{
+		slog.Info("patching marisa::Trie to expose the size of each component of each level")
+		var n int
+		name := "include/marisa/trie.h"
+		files[name], n = bytesTryReplaceAll(files[name],
+			[]byte(`std::size_t io_size() const;`),
+			[]byte("std::size_t io_size() const;\n  void level_sizes(std::size_t level, std::size_t *sizes) const;"))
+		if n != 1 {
+			return fmt.Errorf("failed to apply patch")
+		}
+		name = "lib/marisa/grimoire/trie/louds-trie.h"
+		files[name], n = bytesTryReplaceAll(files[name],
+			[]byte(`std::size_t io_size() const;`),
+			[]byte("std::size_t io_size() const;\n  void level_sizes(std::size_t level, std::size_t *sizes) const;"))
+		if n != 1 {
+			return fmt.Errorf("failed to apply patch")
+		}
+		files["lib/marisa/trie.cc"] = append(files["lib/marisa/trie.cc"], `
+namespace marisa {
+
+void Trie::level_sizes(std::size_t level, std::size_t *sizes) const {
+  if (trie_.get() == nullptr) {
+    throw std::logic_error("trie_ == nullptr");
+  }
+  trie_->level_sizes(level, sizes);
+}
+
+}  // namespace marisa
+`...)
+		files["lib/marisa/grimoire/trie/louds-trie.cc"] = append(files["lib/marisa/grimoire/trie/louds-trie.cc"], `
+namespace marisa::grimoire::trie {
+
+void LoudsTrie::level_sizes(std::size_t level, std::size_t *sizes) const {
+  if (level != 0) {
+    if (next_trie_.get() == nullptr) {
+      throw std::out_of_range("level >= num_tries()");
+    }
+    next_trie_->level_sizes(level - 1, sizes);
+    return;
+  }
+  sizes[0] = louds_.total_size();
+  sizes[1] = terminal_flags_.total_size();
+  sizes[2] = link_flags_.total_size();
+  sizes[3] = bases_.total_size();
+  sizes[4] = extras_.total_size();
+  sizes[5] = tail_.total_size();
+  sizes[6] = cache_.total_size();
+}
+
+}  // namespace marisa::grimoire::trie
+`...)
+	}
 	{
 		slog.Info("cleaning up include/marisa/base.h")
 		src := files["include/marisa/base.h"]
@@ -9,23 +9,35 @@
 // note: most throws only happen due to programmer error or out-of-bound values,
 // other than runtime_error, which gets thrown when a trie is corrupt, and a few
 // invalid_argument ones when it's parsing data (and luckily, the throws that we
-// can't prevent are just during reads of the pre-allocated trie data, so we
-// won't really have a problem with memory leaks even though our fake_throw
-// won't call destructors on stack variables)
+// can't prevent are just during reads of the trie data, so we won't really
+// have a problem with memory leaks even though our fake_throw won't call
+// destructors on stack variables: maps are done in-place so the partially
+// mapped trie is freed with the trie, and a failed read discards the module)
+
+// note: tries are referenced by handles rather than being a single static
+// instance so many dictionaries can share a module (see TrieSet), but there's
+// only one keyset since each build uses its own module
 
-static marisa::Trie trie;
 static marisa::Keyset build;
 
-extern "C" void New(void *ptr, size_t size) {
-    trie.map(ptr, size);
+extern "C" marisa::Trie *TrieNew() {
+    return new marisa::Trie;
+}
+
+extern "C" void TrieFree(marisa::Trie *trie) {
+    delete trie;
+}
+
+extern "C" void TrieMap(marisa::Trie *trie, void *ptr, size_t size) {
+    trie->map_in_place(ptr, size); // the trie must be freed if it throws
 }
 
-extern "C" void Load() {
-    trie.read(0);
+extern "C" void TrieLoad(marisa::Trie *trie) {
+    trie->read(0);
 }
 
-extern "C" void Save() {
-    trie.write(0);
+extern "C" void TrieSave(marisa::Trie *trie) {
+    trie->write(0);
 }
 
 extern "C" void BuildPush(const char *ptr, size_t length, float weight) {
@@ -33,9 +45,9 @@ extern "C" void BuildPush(const char *ptr, size_t length, float weight) {
     //assert(build[build.size()-1].ptr() != ptr);
 }
 
-extern "C" void Build(int flags) {
+extern "C" void TrieBuild(marisa::Trie *trie, int flags) {
     // warning: this will continue to reference pointers to data blocks in keyset
-    trie.build(build, flags);
+    trie->build(build, flags);
 }
 
 struct marisa_stat {
@@ -48,15 +60,39 @@ struct marisa_stat {
     uint32_t node_order;
 };
 
-extern "C" struct marisa_stat Stat() {
+extern "C" struct marisa_stat TrieStat(marisa::Trie *trie) {
     return (struct marisa_stat){
-        .size = static_cast<uint32_t>(trie.size()),
-        .io_size = static_cast<uint32_t>(trie.io_size()),
-        .total_size = static_cast<uint32_t>(trie.total_size()),
-        .num_tries = static_cast<uint32_t>(trie.num_tries()),
-        .num_nodes = static_cast<uint32_t>(trie.num_nodes()),
-        .tail_mode = static_cast<uint32_t>(trie.tail_mode()),
-        .node_order = static_cast<uint32_t>(trie.node_order()),
+        .size = static_cast<uint32_t>(trie->size()),
+        .io_size = static_cast<uint32_t>(trie->io_size()),
+        .total_size = static_cast<uint32_t>(trie->total_size()),
+        .num_tries = static_cast<uint32_t>(trie->num_tries()),
+        .num_nodes = static_cast<uint32_t>(trie->num_nodes()),
+        .tail_mode = static_cast<uint32_t>(trie->tail_mode()),
+        .node_order = static_cast<uint32_t>(trie->node_order()),
+    };
+}
+
+struct marisa_level {
+    uint32_t louds;
+    uint32_t terminal_flags;
+    uint32_t link_flags;
+    uint32_t bases;
+    uint32_t extras;
+    uint32_t tail;
+    uint32_t cache;
+};
+
+extern "C" struct marisa_level TrieLevel(marisa::Trie *trie, uint32_t level) {
+    size_t sizes[7];
+    trie->level_sizes(level, sizes); // throws if level >= trie->num_tries()
+    return (struct marisa_level){
+        .louds = static_cast<uint32_t>(sizes[0]),
+        .terminal_flags = static_cast<uint32_t>(sizes[1]),
+        .link_flags = static_cast<uint32_t>(sizes[2]),
+        .bases = static_cast<uint32_t>(sizes[3]),
+        .extras = static_cast<uint32_t>(sizes[4]),
+        .tail = static_cast<uint32_t>(sizes[5]),
+        .cache = static_cast<uint32_t>(sizes[6]),
     };
 }
 
@@ -86,23 +122,23 @@ extern "C" void QueryFree(marisa::Agent *agent) {
 // return a single node since the agent contains heap-allocated memory which
 // wouldn't get cleaned up if it threw and we had it on the stack
 
-extern "C" bool QueryLookup(marisa::Agent *agent) {
-    return trie.lookup(*agent);
+extern "C" bool TrieLookup(marisa::Trie *trie, marisa::Agent *agent) {
+    return trie->lookup(*agent);
 }
 
-extern "C" bool QueryReverseLookup(marisa::Agent *agent) {
-    if (agent->query().id() >= trie.num_keys()) return false;
-    // note: this will always throw if id >= trie.num_keys()
-    trie.reverse_lookup(*agent);
+extern "C" bool TrieReverseLookup(marisa::Trie *trie, marisa::Agent *agent) {
+    if (agent->query().id() >= trie->num_keys()) return false;
+    // note: this will always throw if id >= trie->num_keys()
+    trie->reverse_lookup(*agent);
     return true;
 }
 
-extern "C" bool QueryCommonPrefixSearch(marisa::Agent *agent) {
-    return trie.common_prefix_search(*agent);
+extern "C" bool TrieCommonPrefixSearch(marisa::Trie *trie, marisa::Agent *agent) {
+    return trie->common_prefix_search(*agent);
 }
 
-extern "C" bool QueryPredictiveSearch(marisa::Agent *agent) {
-    return trie.predictive_search(*agent);
+extern "C" bool TriePredictiveSearch(marisa::Trie *trie, marisa::Agent *agent) {
+    return trie->predictive_search(*agent);
 }
 
 extern "C" struct marisa_query_result {
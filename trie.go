@@ -13,7 +13,7 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/pgaskin/go-marisa/internal/cxxerr"
+	"github.com/pgaskin/go-marisa/internal"
 	"github.com/pgaskin/go-marisa/internal/marisa_wasm"
 	"github.com/pgaskin/go-marisa/internal/wexcept"
 	"github.com/pgaskin/go-marisa/internal/wmem"
@@ -37,10 +37,12 @@ func Initialize() error {
 // On 64-bit systems, the maximum dictionary size is 4GiB. On 32-bit systems,
 // the maximum dictionary size is 2 GiB. Note that if you build/load the same
 // trie twice, it needs twice the amount of memory since it swaps it at the end.
+//
+// If an internal error (see [ErrInternal]) occurs while using the trie, it
+// becomes unusable, and further operations will return the same error.
 type Trie struct {
 	noCopy    noCopy // can't be copied since it's essentialy a handle
 	mod       *module
-	qry       *query // cache the last query (we'll usually only have one at a time unless someone is nesting iterators)
 	size      uint32
 	ioSize    uint32
 	totalSize uint32
@@ -48,6 +50,21 @@ type Trie struct {
 	numNodes  uint32
 	tailMode  TailMode
 	nodeOrder NodeOrder
+
+	cacheLevel CacheLevel // zero if not known yet (see Config)
+	h          *handle    // the dictionary in the module
+}
+
+// Stats contains information about a dictionary. See the corresponding methods
+// on [Trie] for more information.
+type Stats struct {
+	Size      uint32
+	DiskSize  uint32
+	TotalSize uint32
+	NumTries  uint32
+	NumNodes  uint32
+	TailMode  TailMode
+	NodeOrder NodeOrder
 }
 
 // binaryAppender is encoding.BinaryAppender (go1.24)
@@ -62,6 +79,7 @@ var (
 	_ encoding.BinaryUnmarshaler = (*Trie)(nil)
 	_ io.WriterTo                = (*Trie)(nil)
 	_ io.ReaderFrom              = (*Trie)(nil)
+	_ io.Closer                  = (*Trie)(nil)
 )
 
 const scratchSpace = 32 * 1024 * 1024             // scratch space to allocate when the size is known in advance
@@ -72,8 +90,41 @@ type module struct {
 	io      *marisaIOImpl
 	wexcept *wexcept.Module
 	marisa  *marisa_wasm.Module
+	mapped  uint64 // bytes of memory mapped from a file
+	err     error  // if set, the module is unusable due to an internal error or being closed
+}
+
+// handle is a dictionary in a module. It is shared between a trie and its
+// queries so they can tell when it is freed.
+type handle struct {
+	ptr     uint32 // marisa::Trie, zero once freed or closed
+	data    uint32 // serialized dictionary the trie was mapped from, if any
+	dataLen uint32
+	heap    uint64   // heap allocated for the dictionary in a TrieSet
+	set     *TrieSet // set if the module is shared with other dictionaries (see TrieSet)
+	qry     *query   // cache the last query (we'll usually only have one at a time unless someone is nesting iterators)
+}
+
+// growableMemory returns memory which will grow to an unknown size. If
+// supported and well-tested on the current platform, it is non-movable virtual
+// memory so growing it doesn't require copying (which would temporarily double
+// the memory usage). Otherwise, it is slice-backed. On 32-bit platforms, it is
+// always slice-backed since reserving the maximum size would use up most of the
+// address space. Once it is done growing, the reservation should be released
+// with [wmem.Trim].
+func growableMemory(cap, max uint64) wmem.Memory {
+	if !internal.NoVirtualMemory && mmapTested() && math.MaxInt > math.MaxUint32 {
+		if m, err := wmem.VirtualMemory(cap, max); err == nil {
+			return m
+		}
+	}
+	return wmem.SliceMemory(cap, max)
 }
 
+// wrapMemory, if set, wraps the memory of new modules. It is used for fault
+// injection in tests.
+var wrapMemory func(wmem.Memory) wmem.Memory
+
 type impMem struct{ wmem.Memory }
 
 func (e impMem) Xmemory() marisa_wasm.Memory {
@@ -82,13 +133,16 @@ func (e impMem) Xmemory() marisa_wasm.Memory {
 
 // instantiate creates a new instance of the module.
 func instantiate(mem wmem.Memory) (*module, error) {
+	if wrapMemory != nil {
+		mem = wrapMemory(mem)
+	}
 	mod := &module{}
 	mod.mem = mem
 	if mod.mem.Grow(2, math.MaxInt64) < 0 { // initial memory requirement from the wasm module
-		return nil, errors.New("failed to initialize module memory")
+		return nil, errorKind(ErrOutOfMemory, errors.New("failed to initialize module memory"))
 	}
 	mod.io = &marisaIOImpl{Memory: mod.mem}
-	mod.wexcept = &wexcept.Module{Memory: mod.mem}
+	mod.wexcept = &wexcept.Module{Memory: mod.mem, Wrap: wrapException}
 	mod.marisa = marisa_wasm.New(mod.io, mod.wexcept, impMem{mod.mem})
 	mod.wexcept.Imports = mod.marisa
 	runtime.SetFinalizer(mod, func(mod *module) {
@@ -97,13 +151,127 @@ func instantiate(mem wmem.Memory) (*module, error) {
 	return mod, nil
 }
 
+// clone creates a new instance of the module using mem with a copy of the
+// memory and state of m.
+func (m *module) clone(mem wmem.Memory) (*module, error) {
+	c, err := instantiate(mem)
+	if err != nil {
+		return nil, err
+	}
+	src := *m.mem.Slice()
+	if delta := int64(len(src)-len(*c.mem.Slice())) >> wmem.PageBits; delta > 0 {
+		if c.mem.Grow(delta, math.MaxInt64) < 0 {
+			c.Close()
+			return nil, errorKind(ErrOutOfMemory, errors.New("failed to grow module memory"))
+		}
+	}
+	copy(*c.mem.Slice(), src)
+	c.marisa.SetStackPointer(m.marisa.StackPointer())
+	return c, nil
+}
+
+// Close releases the module memory.
+func (m *module) Close() {
+	if errors.Is(m.err, ErrClosed) {
+		return
+	}
+	m.err = errorKind(ErrClosed, nil)
+	runtime.SetFinalizer(m, nil)
+	m.mem.Free()
+}
+
+// fault marks the module as unusable if err is an internal error (since the
+// module state may be inconsistent), then returns err.
+func (m *module) fault(err error) error {
+	if m.err == nil && errors.Is(err, ErrInternal) {
+		m.err = err
+	}
+	return err
+}
+
+// newTrie creates a new dictionary in m, then calls init with it to initialize
+// it. On error, the dictionary is freed.
+func (m *module) newTrie(init func(ptr int32)) (*handle, error) {
+	var ptr uint32
+	if err := func() (err error) {
+		defer wexcept.Catch(&err)
+		defer m.marisa.SetStackPointer(m.marisa.StackPointer())
+		ptr = uint32(m.marisa.XTrieNew())
+		return
+	}(); err != nil {
+		return nil, m.fault(err)
+	}
+	if err := func() (err error) {
+		defer wexcept.Catch(&err)
+		defer m.marisa.SetStackPointer(m.marisa.StackPointer())
+		init(int32(ptr))
+		return
+	}(); err != nil {
+		m.freeTrie(ptr)
+		return nil, err
+	}
+	return &handle{ptr: ptr}, nil
+}
+
+// freeTrie frees a dictionary created by newTrie.
+func (m *module) freeTrie(ptr uint32) error {
+	if err := func() (err error) {
+		defer wexcept.Catch(&err)
+		defer m.marisa.SetStackPointer(m.marisa.StackPointer())
+		m.marisa.XTrieFree(int32(ptr))
+		return
+	}(); err != nil {
+		return m.fault(errorKind(ErrInternal, fmt.Errorf("failed to free dictionary: %w", err)))
+	}
+	return nil
+}
+
+// image returns the serialized dictionary the trie was mapped from, if it is
+// still in module memory.
+func (t *Trie) image() ([]byte, bool) {
+	if t.h.data == 0 {
+		return nil, false
+	}
+	return wmem.Bytes(t.mod.mem, t.h.data, t.h.dataLen)
+}
+
+// err returns the error making an initialized trie unusable, if any.
+func (t *Trie) err() error {
+	if t.h.ptr == 0 {
+		return errorKind(ErrClosed, nil)
+	}
+	return t.mod.err
+}
+
+// trap should be called in a defer statement to catch runtime errors raised by
+// the module (e.g., out-of-bounds memory accesses while reading a corrupt
+// dictionary), setting err to an error matching [ErrCorrupt] and marking the
+// module unusable since its state is unknown. It re-panics other kinds of
+// panics.
+func (m *module) trap(err *error) {
+	if x := recover(); x != nil {
+		if x, ok := x.(runtime.Error); ok {
+			*err = corrupt(-1, "module trapped", x)
+			if m.err == nil {
+				m.err = *err
+			}
+			return
+		}
+		panic(x)
+	}
+}
+
 func (m *module) Alloc(n int) (addr uint32, err error) {
 	if n != 0 {
 		defer wexcept.Catch(&err)
+		defer m.marisa.SetStackPointer(m.marisa.StackPointer())
 		if n < 0 || int64(n) >= math.MaxInt32 {
-			return 0, cxxerr.Error(cxxerr.BadAlloc, "size out of range")
+			return 0, errorKind(ErrTooLarge, errors.New("allocation size out of range"))
 		}
 		addr = uint32(m.marisa.Xmalloc(int32(uint32(n))))
+		if addr == 0 {
+			return 0, errorKind(ErrOutOfMemory, nil)
+		}
 	}
 	return
 }
@@ -114,14 +282,18 @@ func (m *module) Free(addr uint32) {
 	}
 }
 
-// swap sets the dictionary to use the specified mod containing an initialized
-// dictionary, and updates the stats.
-func (t *Trie) swap(mod *module) (err error) {
+// swap sets the dictionary to use the specified dictionary in mod, and updates
+// the stats. If t was in a [TrieSet], it is removed from it.
+func (t *Trie) swap(mod *module, h *handle) (err error) {
 	defer wexcept.Catch(&err)
-	size, ioSize, totalSize, numTries, numNodes, tailMode, nodeOrder := mod.marisa.XStat()
+	defer mod.marisa.SetStackPointer(mod.marisa.StackPointer())
+	size, ioSize, totalSize, numTries, numNodes, tailMode, nodeOrder := mod.marisa.XTrieStat(int32(h.ptr))
+	if t.mod != nil && t.h.set != nil {
+		t.free()
+	}
 	*t = Trie{
 		mod:       mod,
-		qry:       nil,
+		h:         h,
 		size:      uint32(size),
 		ioSize:    uint32(ioSize),
 		totalSize: uint32(totalSize),
@@ -133,6 +305,29 @@ func (t *Trie) swap(mod *module) (err error) {
 	return nil
 }
 
+// Close immediately releases the memory used by the dictionary, including file
+// mappings. Afterwards, all operations on the dictionary (including outstanding
+// iterators) will return an error matching [ErrClosed]. The trie can be reused
+// by building or loading a new dictionary into it. If the trie is not
+// initialized, Close does nothing. If it is already closed, it returns an error
+// matching [ErrClosed].
+func (t *Trie) Close() error {
+	if t.mod == nil {
+		return nil
+	}
+	if err := t.err(); errors.Is(err, ErrClosed) {
+		return err
+	}
+	if t.h.set != nil {
+		t.free()
+		return nil
+	}
+	t.mod.Close()
+	t.h.ptr = 0
+	t.h.qry = nil
+	return nil
+}
+
 // String returns a human-readable description of the dictionary.
 func (t *Trie) String() string {
 	var b strings.Builder
@@ -140,6 +335,8 @@ func (t *Trie) String() string {
 	b.WriteString("(")
 	if t.mod == nil {
 		b.WriteString("uninitialized")
+	} else if errors.Is(t.err(), ErrClosed) {
+		b.WriteString("closed")
 	} else {
 		b.WriteString("size=")
 		b.WriteString(strconv.FormatUint(uint64(t.size), 10))
@@ -160,6 +357,20 @@ func (t *Trie) String() string {
 	return b.String()
 }
 
+// Stats returns information about the dictionary. If the trie is not
+// initialized, it returns the zero value.
+func (t *Trie) Stats() Stats {
+	return Stats{
+		Size:      t.size,
+		DiskSize:  t.ioSize,
+		TotalSize: t.totalSize,
+		NumTries:  t.numTries,
+		NumNodes:  t.numNodes,
+		TailMode:  t.tailMode,
+		NodeOrder: t.nodeOrder,
+	}
+}
+
 // Size returns the number of keys in the dictionary. Key are numbered from 0 to
 // Size-1.
 func (t *Trie) Size() uint32 {
@@ -4,11 +4,13 @@ import (
 	"bytes"
 	"crypto/sha1"
 	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"iter"
 	"os"
+	"path/filepath"
 	"runtime"
 	"slices"
 	"strings"
@@ -22,6 +24,7 @@ import (
 
 func init() {
 	flag.BoolVar(&internal.NoCacheQuery, "marisa.nocachequery", false, "disable query agent caching")
+	flag.BoolVar(&internal.NoVirtualMemory, "marisa.novirtualmemory", false, "use slice-backed memory for building and reading")
 }
 
 func TestMain(m *testing.M) {
@@ -30,6 +33,9 @@ func TestMain(m *testing.M) {
 	if internal.NoCacheQuery {
 		fmt.Println("marisa: query agent caching disabled by flag")
 	}
+	if internal.NoVirtualMemory {
+		fmt.Println("marisa: virtual memory disabled by flag")
+	}
 
 	defer os.Exit(m.Run())
 }
@@ -189,6 +195,113 @@ func TestStats(t *testing.T) {
 	})
 }
 
+func TestClose(t *testing.T) {
+	expected := mustWordsTrieData()
+
+	assertClosed := func(t *testing.T, trie *marisa.Trie) {
+		t.Helper()
+		if _, _, err := trie.Lookup("test"); !errors.Is(err, marisa.ErrClosed) {
+			t.Errorf("lookup: expected closed error, got %v", err)
+		}
+		if _, _, err := trie.ReverseLookup(0); !errors.Is(err, marisa.ErrClosed) {
+			t.Errorf("reverse lookup: expected closed error, got %v", err)
+		}
+		if _, err := trie.PredictiveSearch("", -1); !errors.Is(err, marisa.ErrClosed) {
+			t.Errorf("predictive search: expected closed error, got %v", err)
+		}
+		if _, err := trie.MarshalBinary(); !errors.Is(err, marisa.ErrClosed) {
+			t.Errorf("marshal: expected closed error, got %v", err)
+		}
+		if _, err := trie.WriteTo(io.Discard); !errors.Is(err, marisa.ErrClosed) {
+			t.Errorf("write: expected closed error, got %v", err)
+		}
+		if s := trie.String(); !strings.HasSuffix(s, ".Trie(closed)") {
+			t.Errorf("incorrect String() value %q for closed trie", s)
+		}
+	}
+
+	t.Run("Zero", func(t *testing.T) {
+		var trie marisa.Trie
+		if err := trie.Close(); err != nil {
+			t.Errorf("error: %v", err)
+		}
+	})
+
+	t.Run("Simple", func(t *testing.T) {
+		trie := mustWordsTrie()
+		if err := trie.Close(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		assertClosed(t, trie)
+		if err := trie.Close(); !errors.Is(err, marisa.ErrClosed) {
+			t.Errorf("expected closed error for second close, got %v", err)
+		}
+	})
+
+	t.Run("MapFile", func(t *testing.T) {
+		filename := filepath.Join(t.TempDir(), "words.dat")
+		if err := os.WriteFile(filename, expected, 0666); err != nil {
+			panic(err)
+		}
+
+		f, err := os.Open(filename)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+
+		var trie marisa.Trie
+		if err := trie.MapFile(f, 0, int64(len(expected))); err != nil {
+			if errors.Is(err, errors.ErrUnsupported) {
+				t.Skipf("unsupported platform: %v", err)
+			}
+			t.Fatalf("error: %v", err)
+		}
+		if err := trie.Close(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		assertClosed(t, &trie)
+	})
+
+	t.Run("Iterator", func(t *testing.T) {
+		trie := mustWordsTrie()
+
+		var (
+			err error
+			n   int
+		)
+		for range trie.PredictiveSearchSeq("a")(&err) {
+			if n++; n == 10 {
+				if err := trie.Close(); err != nil {
+					t.Fatalf("error: %v", err)
+				}
+			}
+		}
+		if !errors.Is(err, marisa.ErrClosed) {
+			t.Errorf("expected closed error from iterator, got %v", err)
+		}
+		if n != 10 {
+			t.Errorf("expected iteration to stop after close, got %d results", n)
+		}
+		assertClosed(t, trie)
+	})
+
+	t.Run("Reuse", func(t *testing.T) {
+		trie := mustWordsTrie()
+		if err := trie.Close(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if err := trie.UnmarshalBinary(expected); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if buf, err := trie.MarshalBinary(); err != nil {
+			t.Errorf("error: %v", err)
+		} else if !bytes.Equal(buf, expected) {
+			t.Errorf("round-trip failed")
+		}
+	})
+}
+
 func BenchmarkTrie(b *testing.B) {
 	benchmarkTrie(b, "Words",
 		slices.Values(testdata.Words),
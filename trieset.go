@@ -0,0 +1,161 @@
+package marisa
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+
+	"github.com/pgaskin/go-marisa/internal/wmem"
+)
+
+// TrieSet holds many dictionaries in a single module instance, avoiding the
+// overhead of a separate module for each one (see [Trie.MemoryStats]). This is
+// useful for large numbers of small dictionaries.
+//
+// The dictionaries are copied into the module memory, and each one is mapped
+// from its copy independently, so there is no additional cost to interleaving
+// operations on different dictionaries.
+//
+// All dictionaries in the set share the module memory, so the maximum total
+// size is the same as the maximum size of a single [Trie]. [Trie.MemoryStats]
+// only reports the memory allocated for each dictionary, and
+// [TrieSet.MemoryStats] reports the memory used by the entire set. If an
+// internal error occurs, all dictionaries in the set become unusable.
+//
+// A TrieSet and the tries loaded into it must not be used concurrently.
+type TrieSet struct {
+	noCopy noCopy
+	mod    *module
+	tries  int
+
+	mu      sync.Mutex // protects pending, which is added to by cleanups
+	pending []*handle  // dictionaries of collected tries, to be freed by collect
+}
+
+// NewTrieSet creates a new empty [TrieSet].
+func NewTrieSet() (*TrieSet, error) {
+	mod, err := instantiate(growableMemory(0, maxAlloc))
+	if err != nil {
+		return nil, err
+	}
+	return &TrieSet{mod: mod}, nil
+}
+
+// Load copies the dictionary in b into the set, returning a new trie for it.
+// The trie can be used like any other, and closing it removes it from the set.
+// Loading another dictionary into it (e.g., with [Trie.UnmarshalBinary])
+// removes it from the set and gives it its own module. If the trie is garbage
+// collected without being closed, it is removed from the set during the next
+// call to Load, Len, or Close.
+func (s *TrieSet) Load(b []byte) (*Trie, error) {
+	if s.mod.err != nil {
+		return nil, s.mod.err
+	}
+	s.collect()
+	if uint64(len(b)) > maxAlloc {
+		return nil, errorKind(ErrTooLarge, nil)
+	}
+	inUse, _, ok := s.mod.marisa.MallocStats()
+	ptr, err := s.mod.Alloc(len(b))
+	if err != nil {
+		return nil, s.mod.fault(err)
+	}
+	if buf, ok := wmem.Bytes(s.mod.mem, ptr, uint32(len(b))); !ok {
+		s.mod.Free(ptr)
+		return nil, s.mod.fault(internalError("bad allocation"))
+	} else {
+		copy(buf, b)
+	}
+	h, err := s.mod.newTrie(func(p int32) {
+		s.mod.marisa.XTrieMap(p, int32(ptr), int32(uint32(len(b))))
+	})
+	if err != nil {
+		s.mod.Free(ptr)
+		return nil, s.mod.fault(err)
+	}
+	h.data, h.dataLen, h.set = ptr, uint32(len(b)), s
+
+	h.heap = uint64(len(b))
+	if after, _, ok2 := s.mod.marisa.MallocStats(); ok && ok2 && after > inUse {
+		h.heap = uint64(after - inUse)
+	}
+
+	var t Trie
+	if err := t.swap(s.mod, h); err != nil {
+		s.mod.freeTrie(h.ptr)
+		s.mod.Free(ptr)
+		return nil, s.mod.fault(err)
+	}
+	s.tries++
+	runtime.AddCleanup(&t, func(h *handle) {
+		s.mu.Lock()
+		s.pending = append(s.pending, h)
+		s.mu.Unlock()
+	}, h)
+	return &t, nil
+}
+
+// Len returns the number of tries in the set.
+func (s *TrieSet) Len() int {
+	s.collect()
+	return s.tries
+}
+
+// MemoryStats returns information about the memory used by the module shared
+// by the tries in the set. If the set is closed, it returns the zero value.
+func (s *TrieSet) MemoryStats() MemoryStats {
+	if errors.Is(s.mod.err, ErrClosed) {
+		return MemoryStats{}
+	}
+	return s.mod.memoryStats()
+}
+
+// Close releases the module memory. Afterwards, all tries in the set are closed.
+// If the set was already closed, it returns an error matching [ErrClosed].
+func (s *TrieSet) Close() error {
+	if errors.Is(s.mod.err, ErrClosed) {
+		return s.mod.err
+	}
+	s.mod.Close()
+	s.tries = 0
+	s.mu.Lock()
+	s.pending = nil
+	s.mu.Unlock()
+	return nil
+}
+
+// free removes the dictionary from the shared module, closing t.
+func (t *Trie) free() {
+	t.h.set.free(t.h)
+}
+
+// free removes the dictionary h from the shared module.
+func (s *TrieSet) free(h *handle) {
+	q, ptr := h.qry, h.ptr
+	h.ptr, h.qry = 0, nil
+	if ptr == 0 || s.mod.err != nil {
+		return
+	}
+	if q != nil {
+		var err error
+		q.release(&err) // it won't be cached since the dictionary was freed
+	}
+	if s.mod.freeTrie(ptr) == nil {
+		s.mod.Free(h.data)
+	}
+	h.data = 0
+	s.tries--
+}
+
+// collect frees the dictionaries of tries which were garbage collected without
+// being closed.
+func (s *TrieSet) collect() {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+
+	for _, h := range pending {
+		s.free(h)
+	}
+}
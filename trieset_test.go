@@ -0,0 +1,262 @@
+package marisa_test
+
+import (
+	"errors"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/pgaskin/go-marisa"
+	"github.com/pgaskin/go-marisa/testdata"
+)
+
+func TestTrieSet(t *testing.T) {
+	// a bunch of small dictionaries, plus a large one
+	var (
+		data [][]byte
+		keys [][]string
+	)
+	for i := range 50 {
+		var k []string
+		for j, word := range testdata.Words[i*100:] {
+			if j%(i+1) == 0 {
+				k = append(k, word)
+			}
+			if len(k) == 20 {
+				break
+			}
+		}
+		var trie marisa.Trie
+		if err := trie.Build(slices.Values(k), marisa.Config{}); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		buf, err := trie.MarshalBinary()
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		trie.Close()
+		data, keys = append(data, buf), append(keys, k)
+	}
+	data, keys = append(data, mustWordsTrieData()), append(keys, testdata.Words)
+
+	set, err := marisa.NewTrieSet()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	defer set.Close()
+
+	tries := make([]*marisa.Trie, len(data))
+	for i, buf := range data {
+		if tries[i], err = set.Load(buf); err != nil {
+			t.Fatalf("load %d: %v", i, err)
+		}
+	}
+	if act := set.Len(); act != len(tries) {
+		t.Errorf("expected %d tries, got %d", len(tries), act)
+	}
+	if _, err := set.Load([]byte("not a dictionary")); !errors.Is(err, marisa.ErrCorrupt) {
+		t.Errorf("expected corrupt error, got %v", err)
+	}
+
+	// checkAll checks every trie which isn't closed, interleaving them
+	checkAll := func(t *testing.T, closed ...int) {
+		t.Helper()
+		for j := range 20 {
+			for i, trie := range tries {
+				if slices.Contains(closed, i) {
+					continue
+				}
+				key := keys[i][j*len(keys[i])/20]
+				id, ok, err := trie.Lookup(key)
+				if err != nil || !ok {
+					t.Fatalf("trie %d: lookup %q: %v", i, key, err)
+				}
+				if act, ok, err := trie.ReverseLookup(id); err != nil || !ok || act != key {
+					t.Fatalf("trie %d: reverse lookup %d: expected %q, got %q (%v)", i, id, key, act, err)
+				}
+			}
+		}
+	}
+	checkAll(t)
+
+	t.Run("Nested", func(t *testing.T) {
+		a, b := tries[0], tries[len(tries)-1]
+		var n int
+		for _, key := range a.DumpSeq()(&err) {
+			if _, ok, err := b.Lookup(key); err != nil {
+				t.Fatalf("lookup %q: %v", key, err)
+			} else if !ok {
+				t.Errorf("expected %q to be in the large dictionary", key)
+			}
+			n++
+		}
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if n != len(keys[0]) {
+			t.Errorf("expected %d keys, got %d", len(keys[0]), n)
+		}
+	})
+
+	t.Run("Serialize", func(t *testing.T) {
+		for i, trie := range []*marisa.Trie{tries[1], tries[len(tries)-1], tries[2]} {
+			if buf, err := trie.MarshalBinary(); err != nil {
+				t.Errorf("%d: error: %v", i, err)
+			} else if act, err := marisa.New(buf); err != nil {
+				t.Errorf("%d: error: %v", i, err)
+			} else if act.Size() != trie.Size() {
+				t.Errorf("%d: round-trip failed", i)
+			}
+		}
+	})
+
+	t.Run("Memory", func(t *testing.T) {
+		var total uint64
+		for _, buf := range data[:len(data)-1] {
+			trie, err := marisa.New(buf)
+			if err != nil {
+				t.Fatalf("error: %v", err)
+			}
+			total += trie.MemoryStats().Size
+			trie.Close()
+		}
+		total += mustWordsTrie().MemoryStats().Size
+		act := set.MemoryStats()
+		var heap uint64
+		for i, trie := range tries {
+			s := trie.MemoryStats()
+			if s != (marisa.MemoryStats{HeapInUse: s.HeapInUse}) {
+				t.Errorf("%d: expected only the heap usage of the trie, got %+v", i, s)
+			}
+			if s.HeapInUse < uint64(len(data[i])) {
+				t.Errorf("%d: expected the heap usage to include the dictionary, got %d", i, s.HeapInUse)
+			}
+			heap += s.HeapInUse
+		}
+		if heap > act.HeapInUse {
+			t.Errorf("expected the tries to use less heap than the set (%d vs %d)", heap, act.HeapInUse)
+		}
+		if act.Size*2 > total {
+			t.Errorf("expected the set to use much less memory than separate tries (%d vs %d)", act.Size, total)
+		}
+		t.Logf("set = %d, separate = %d", act.Size, total)
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		var err error
+		for range tries[3].DumpSeq()(&err) {
+			if err := tries[3].Close(); err != nil {
+				t.Fatalf("error: %v", err)
+			}
+		}
+		if !errors.Is(err, marisa.ErrClosed) {
+			t.Errorf("expected in-progress iteration to fail with a closed error, got %v", err)
+		}
+		if _, _, err := tries[3].Lookup(keys[3][0]); !errors.Is(err, marisa.ErrClosed) {
+			t.Errorf("expected closed error, got %v", err)
+		}
+		if err := tries[3].Close(); !errors.Is(err, marisa.ErrClosed) {
+			t.Errorf("expected closed error, got %v", err)
+		}
+		if act := set.Len(); act != len(tries)-1 {
+			t.Errorf("expected %d tries, got %d", len(tries)-1, act)
+		}
+		checkAll(t, 3)
+	})
+
+	t.Run("Reload", func(t *testing.T) {
+		if err := tries[4].UnmarshalBinary(data[5]); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		keys[4] = keys[5]
+		if act := set.Len(); act != len(tries)-2 {
+			t.Errorf("expected %d tries, got %d", len(tries)-2, act)
+		}
+		if act := tries[4].MemoryStats(); act.Size == 0 {
+			t.Errorf("expected reloaded trie to have its own module")
+		}
+		checkAll(t, 3)
+	})
+
+	t.Run("Leak", func(t *testing.T) {
+		// dictionaries removed from the set must be freed
+		stats := set.MemoryStats()
+		for i := range 1000 {
+			j := 5 + i%10
+			trie, err := set.Load(data[j])
+			if err != nil {
+				t.Fatalf("load: %v", err)
+			}
+			if _, ok, err := trie.Lookup(keys[j][0]); err != nil || !ok {
+				t.Fatalf("lookup: %v", err)
+			}
+			if i%2 == 0 {
+				err = trie.Close()
+			} else {
+				err = trie.UnmarshalBinary(data[0])
+			}
+			if err != nil {
+				t.Fatalf("error: %v", err)
+			}
+		}
+		if act := set.MemoryStats(); act.Size != stats.Size || act.HeapInUse != stats.HeapInUse {
+			t.Errorf("expected set memory to stay at %+v, got %+v", stats, act)
+		}
+		if act := set.Len(); act != len(tries)-2 {
+			t.Errorf("expected %d tries, got %d", len(tries)-2, act)
+		}
+		checkAll(t, 3)
+	})
+
+	t.Run("Collect", func(t *testing.T) {
+		// dictionaries of tries which weren't closed must be freed once they
+		// are garbage collected
+		stats := set.MemoryStats()
+		for i := range 100 {
+			j := 5 + i%10
+			trie, err := set.Load(data[j])
+			if err != nil {
+				t.Fatalf("load: %v", err)
+			}
+			if _, ok, err := trie.Lookup(keys[j][0]); err != nil || !ok {
+				t.Fatalf("lookup: %v", err)
+			}
+		}
+		for range 100 {
+			runtime.GC()
+			if set.Len() == len(tries)-2 {
+				break
+			}
+			time.Sleep(10 * time.Millisecond) // cleanups run asynchronously
+		}
+		if act := set.Len(); act != len(tries)-2 {
+			t.Errorf("expected %d tries, got %d", len(tries)-2, act)
+		}
+		if act := set.MemoryStats(); act.HeapInUse != stats.HeapInUse {
+			t.Errorf("expected set heap usage to return to %d, got %d", stats.HeapInUse, act.HeapInUse)
+		}
+		checkAll(t, 3)
+	})
+
+	if err := set.Close(); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	for i, trie := range tries {
+		if i == 4 {
+			continue // reloaded
+		}
+		if _, _, err := trie.Lookup("a"); !errors.Is(err, marisa.ErrClosed) {
+			t.Errorf("trie %d: expected closed error, got %v", i, err)
+		}
+	}
+	if _, _, err := tries[4].Lookup(keys[4][0]); err != nil {
+		t.Errorf("expected reloaded trie to still work, got %v", err)
+	}
+	if _, err := set.Load(data[0]); !errors.Is(err, marisa.ErrClosed) {
+		t.Errorf("expected closed error, got %v", err)
+	}
+	if err := set.Close(); !errors.Is(err, marisa.ErrClosed) {
+		t.Errorf("expected closed error, got %v", err)
+	}
+}
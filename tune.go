@@ -0,0 +1,292 @@
+package marisa
+
+import (
+	"cmp"
+	"errors"
+	"iter"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+// TuneObjective scores a candidate configuration measured by [Tune]. Lower
+// scores are better.
+type TuneObjective func(*TuneResult) float64
+
+// These are common objectives for [Tune].
+var (
+	MinimizeSize       TuneObjective = func(r *TuneResult) float64 { return r.SizeRatio }
+	MinimizeLookup     TuneObjective = func(r *TuneResult) float64 { return r.LookupRatio }
+	MinimizePredictive TuneObjective = func(r *TuneResult) float64 { return r.PredictiveRatio }
+)
+
+// WeightedObjective returns an objective which combines the size, p99 lookup
+// latency, and p99 predictive search latency of each candidate relative to the
+// best candidate for each.
+func WeightedObjective(size, lookup, predictive float64) TuneObjective {
+	return func(r *TuneResult) float64 {
+		return size*r.SizeRatio + lookup*r.LookupRatio + predictive*r.PredictiveRatio
+	}
+}
+
+// TuneOptions configures [TuneWithOptions].
+type TuneOptions struct {
+	// Objective scores the candidates. If nil, [MinimizeSize] is used.
+	Objective TuneObjective
+
+	// Candidates are the configurations to try. If nil, all combinations of 1-5
+	// tries, small/normal/large caches, text/binary tails, and label/weight
+	// order are tried.
+	Candidates []Config
+
+	// Queries are the keys used to measure latency. For predictive searches,
+	// the first half of each query is used as the prefix. If nil, a random
+	// sample of the keys the candidates are built from is used.
+	Queries []string
+
+	// SampleSize is the maximum number of keys to build the candidates from.
+	// If there are more keys, a random sample is used. If zero, it defaults to
+	// 100000. If negative, all keys are used, which may be very slow for large
+	// key sets since every candidate is built from them.
+	SampleSize int
+}
+
+// TuneResult is the measured performance of a candidate configuration.
+type TuneResult struct {
+	Config Config
+	Stats  Stats
+
+	// These are the percentiles of the latency of each query. Since most
+	// queries take less time than the timer resolution, each query is repeated
+	// several times, and the time is divided by the number of repetitions.
+	LookupP50     time.Duration
+	LookupP99     time.Duration
+	PredictiveP50 time.Duration
+	PredictiveP99 time.Duration
+
+	// These are the disk size and p99 latencies relative to the best of all
+	// candidates (i.e., 1 is the best).
+	SizeRatio       float64
+	LookupRatio     float64
+	PredictiveRatio float64
+
+	Score float64
+}
+
+// tuneQueries is the number of keys to sample for queries if not specified.
+const tuneQueries = 1000
+
+// tunePredictiveLimit is the maximum number of keys to iterate for each
+// predictive search.
+const tunePredictiveLimit = 10
+
+// tuneSampleSize is the default maximum number of keys to build candidates
+// from.
+const tuneSampleSize = 100000
+
+// tuneMinTiming is the minimum amount of time to repeat each query for, so it
+// is well above the timer resolution.
+const tuneMinTiming = 10 * time.Microsecond
+
+// Tune returns the configuration which best satisfies objective for the
+// specified keys. See [TuneWithOptions] for more information.
+func Tune(keys iter.Seq2[string, float32], objective TuneObjective) (Config, error) {
+	rs, err := TuneWithOptions(keys, TuneOptions{Objective: objective})
+	if err != nil {
+		return Config{}, err
+	}
+	return rs[0].Config, nil
+}
+
+// TuneWithOptions builds the keys using each candidate configuration, measures
+// the size and query latency of each one, and returns the results ordered from
+// best to worst. Since the measurements depend on the current system and load,
+// the results may not be consistent between runs for similar candidates.
+func TuneWithOptions(keys iter.Seq2[string, float32], opt TuneOptions) ([]TuneResult, error) {
+	objective := opt.Objective
+	if objective == nil {
+		objective = MinimizeSize
+	}
+	candidates := opt.Candidates
+	if candidates == nil {
+		for numTries := 1; numTries <= 5; numTries++ {
+			for _, cacheLevel := range []CacheLevel{SmallCache, NormalCache, LargeCache} {
+				for _, tailMode := range []TailMode{TextTail, BinaryTail} {
+					for _, nodeOrder := range []NodeOrder{LabelOrder, WeightOrder} {
+						candidates = append(candidates, Config{
+							NumTries:   numTries,
+							CacheLevel: cacheLevel,
+							TailMode:   tailMode,
+							NodeOrder:  nodeOrder,
+						})
+					}
+				}
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, errorKind(ErrInvalidConfig, errors.New("no candidate configurations"))
+	}
+
+	ks, err := NewKeyset()
+	if err != nil {
+		return nil, err
+	}
+	defer ks.Close()
+
+	// use a fixed seed so the results only depend on the keys
+	rng := rand.New(rand.NewPCG(0, 0))
+
+	sampleSize := opt.SampleSize
+	if sampleSize == 0 {
+		sampleSize = tuneSampleSize
+	}
+
+	var (
+		queries = opt.Queries
+		sample  []sampleKey
+		n       int
+	)
+	for key, weight := range keys {
+		n++
+		if opt.Queries == nil && sampleSize <= 0 {
+			if len(queries) < tuneQueries {
+				queries = append(queries, key)
+			} else if i := rng.IntN(n); i < tuneQueries {
+				queries[i] = key
+			}
+		}
+		if sampleSize > 0 {
+			if len(sample) < sampleSize {
+				sample = append(sample, sampleKey{key, weight})
+			} else if i := rng.IntN(n); i < sampleSize {
+				sample[i] = sampleKey{key, weight}
+			}
+			continue
+		}
+		if err := ks.Add(key, weight); err != nil {
+			return nil, err
+		}
+	}
+	for _, k := range sample {
+		if err := ks.Add(k.Key, k.Weight); err != nil {
+			return nil, err
+		}
+	}
+	if opt.Queries == nil && len(sample) != 0 {
+		// only query keys which are actually in the candidates
+		for _, i := range rng.Perm(len(sample))[:min(len(sample), tuneQueries)] {
+			queries = append(queries, sample[i].Key)
+		}
+	}
+	sample = nil
+
+	rs := make([]TuneResult, len(candidates))
+	for i, cfg := range candidates {
+		if err := tune(&rs[i], ks, cfg, queries); err != nil {
+			return nil, err
+		}
+	}
+
+	var minSize, minLookup, minPredictive float64
+	for i, r := range rs {
+		if i == 0 || float64(r.Stats.DiskSize) < minSize {
+			minSize = float64(r.Stats.DiskSize)
+		}
+		if i == 0 || float64(r.LookupP99) < minLookup {
+			minLookup = float64(r.LookupP99)
+		}
+		if i == 0 || float64(r.PredictiveP99) < minPredictive {
+			minPredictive = float64(r.PredictiveP99)
+		}
+	}
+	for i := range rs {
+		r := &rs[i]
+		r.SizeRatio = ratio(float64(r.Stats.DiskSize), minSize)
+		r.LookupRatio = ratio(float64(r.LookupP99), minLookup)
+		r.PredictiveRatio = ratio(float64(r.PredictiveP99), minPredictive)
+		r.Score = objective(r)
+	}
+	slices.SortStableFunc(rs, func(a, b TuneResult) int {
+		return cmp.Compare(a.Score, b.Score)
+	})
+	return rs, nil
+}
+
+type sampleKey struct {
+	Key    string
+	Weight float32
+}
+
+// tune builds and measures a single candidate.
+func tune(r *TuneResult, ks *Keyset, cfg Config, queries []string) error {
+	var trie Trie
+	if err := trie.BuildKeyset(ks, cfg); err != nil {
+		return err
+	}
+	defer trie.Close()
+
+	r.Config = cfg
+	r.Stats = trie.Stats()
+
+	lookup := func(q string) error {
+		_, _, err := trie.Lookup(q)
+		return err
+	}
+	predictive := func(q string) (err error) {
+		var n int
+		for range trie.PredictiveSearchSeq(q[:(len(q)+1)/2])(&err) {
+			if n++; n >= tunePredictiveLimit {
+				break
+			}
+		}
+		return
+	}
+
+	var err error
+	if r.LookupP50, r.LookupP99, err = latency(queries, lookup); err != nil {
+		return err
+	}
+	if r.PredictiveP50, r.PredictiveP99, err = latency(queries, predictive); err != nil {
+		return err
+	}
+	return nil
+}
+
+// latency returns the p50 and p99 latency of fn for each query after warming
+// up. Each query is timed separately, and repeated until it takes at least
+// tuneMinTiming.
+func latency(queries []string, fn func(string) error) (p50, p99 time.Duration, err error) {
+	if len(queries) == 0 {
+		return 0, 0, nil
+	}
+	for _, q := range queries {
+		if err := fn(q); err != nil {
+			return 0, 0, err
+		}
+	}
+	ds := make([]time.Duration, len(queries))
+	for i, q := range queries {
+		for reps := 1; ; reps *= 2 {
+			start := time.Now()
+			for range reps {
+				if err := fn(q); err != nil {
+					return 0, 0, err
+				}
+			}
+			if d := time.Since(start); d >= tuneMinTiming {
+				ds[i] = d / time.Duration(reps)
+				break
+			}
+		}
+	}
+	slices.Sort(ds)
+	return ds[len(ds)*50/100], ds[min(len(ds)-1, len(ds)*99/100)], nil
+}
+
+// ratio returns v relative to best, which is clamped to at least 1 (i.e., 1ns
+// for latencies, which could be zero if the timer resolution is low).
+func ratio(v, best float64) float64 {
+	best = max(best, 1)
+	return max(v, best) / best
+}
@@ -0,0 +1,104 @@
+package marisa_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pgaskin/go-marisa"
+	"github.com/pgaskin/go-marisa/testdata"
+)
+
+func TestTune(t *testing.T) {
+	words := func(yield func(string, float32) bool) {
+		for _, word := range testdata.Words {
+			if !yield(word, 1) {
+				return
+			}
+		}
+	}
+
+	t.Run("Size", func(t *testing.T) {
+		rs, err := marisa.TuneWithOptions(words, marisa.TuneOptions{
+			Objective:  marisa.MinimizeSize,
+			SampleSize: 20000,
+		})
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if len(rs) != 60 {
+			t.Errorf("expected 60 default candidates, got %d", len(rs))
+		}
+		tails := map[marisa.TailMode]bool{}
+		for _, r := range rs {
+			tails[r.Config.TailMode] = true
+		}
+		if !tails[marisa.TextTail] || !tails[marisa.BinaryTail] {
+			t.Errorf("expected candidates with both tail modes, got %v", tails)
+		}
+		for _, r := range rs {
+			t.Logf("%+v size=%d lookup=%s/%s predictive=%s/%s score=%.3f", r.Config, r.Stats.DiskSize, r.LookupP50, r.LookupP99, r.PredictiveP50, r.PredictiveP99, r.Score)
+			if exp := uint32(20000); r.Stats.Size != exp {
+				t.Errorf("expected %d keys, got %d", exp, r.Stats.Size)
+			}
+			if r.Stats.DiskSize < rs[0].Stats.DiskSize {
+				t.Errorf("%+v is smaller than the best result", r.Config)
+			}
+			if r.LookupP99 == 0 || r.PredictiveP99 == 0 {
+				t.Errorf("%+v: expected latency to be measured", r.Config)
+			}
+		}
+		if rs[0].SizeRatio != 1 || rs[0].Score != 1 {
+			t.Errorf("expected best result to have a size ratio of 1")
+		}
+	})
+	t.Run("Weighted", func(t *testing.T) {
+		cfg := []marisa.Config{
+			{NumTries: 1, CacheLevel: marisa.HugeCache},
+			{NumTries: 5, CacheLevel: marisa.TinyCache},
+		}
+		rs, err := marisa.TuneWithOptions(words, marisa.TuneOptions{
+			Objective:  marisa.WeightedObjective(1, 0.5, 0.5),
+			Candidates: cfg,
+			Queries:    testdata.Words[:100],
+			SampleSize: 5000,
+		})
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if len(rs) != len(cfg) {
+			t.Fatalf("expected %d results, got %d", len(cfg), len(rs))
+		}
+		for _, r := range rs {
+			if exp := r.SizeRatio + 0.5*r.LookupRatio + 0.5*r.PredictiveRatio; r.Score != exp {
+				t.Errorf("%+v: expected score %f, got %f", r.Config, exp, r.Score)
+			}
+		}
+		if rs[0].Score > rs[1].Score {
+			t.Errorf("results not sorted by score")
+		}
+	})
+	t.Run("Tune", func(t *testing.T) {
+		cfg, err := marisa.Tune(func(yield func(string, float32) bool) {
+			for _, word := range testdata.Words[:2000] {
+				if !yield(word, 1) {
+					return
+				}
+			}
+		}, marisa.MinimizeLookup)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		var trie marisa.Trie
+		if err := trie.Build(func(yield func(string) bool) {}, cfg); err != nil {
+			t.Errorf("tuned config %+v is invalid: %v", cfg, err)
+		}
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		if _, err := marisa.TuneWithOptions(words, marisa.TuneOptions{Candidates: []marisa.Config{}}); !errors.Is(err, marisa.ErrInvalidConfig) {
+			t.Errorf("expected invalid config error, got %v", err)
+		}
+		if _, err := marisa.TuneWithOptions(words, marisa.TuneOptions{Candidates: []marisa.Config{{NumTries: -1}}, SampleSize: 100}); !errors.Is(err, marisa.ErrInvalidConfig) {
+			t.Errorf("expected invalid config error, got %v", err)
+		}
+	})
+}
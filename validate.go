@@ -0,0 +1,145 @@
+package marisa
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/pgaskin/go-marisa/internal/layout"
+)
+
+// Validate reads a serialized dictionary from r until EOF, and checks that it
+// is well-formed. This includes the header, section sizes, bit vector
+// rank/select indexes, and that every key ID reverse-looks-up to a key which
+// looks up to the same ID. If the dictionary is corrupt, an error matching
+// [ErrCorrupt] (usually with a [*CorruptError] describing the problem) is
+// returned.
+//
+// This reads the entire dictionary into memory, and is much slower than
+// loading it. It is intended for dictionaries from untrusted sources, which may
+// otherwise fail in unexpected ways long after being loaded.
+func Validate(r io.Reader) error {
+	b, err := io.ReadAll(io.LimitReader(r, maxAlloc+1))
+	if err != nil {
+		return err
+	}
+	if uint64(len(b)) > maxAlloc {
+		return errorKind(ErrTooLarge, nil)
+	}
+	if err := validateLayout(b); err != nil {
+		return err
+	}
+	var t Trie
+	if err := t.UnmarshalBinary(b); err != nil {
+		if errors.Is(err, ErrCorrupt) {
+			return err
+		}
+		return corrupt(-1, "failed to load", err)
+	}
+	return t.verifyKeys()
+}
+
+// Verify checks that the loaded dictionary is well-formed, like [Validate]. If
+// it was mapped or unmarshaled, it is checked in place. Otherwise (e.g., if it
+// was built or read), it is serialized first. If the dictionary is corrupt
+// enough to crash a query, the trie becomes unusable.
+func (t *Trie) Verify() error {
+	if err := t.verifyLayout(true); err != nil {
+		return err
+	}
+	return t.verifyKeys()
+}
+
+// verifySizes checks the header and section sizes of the loaded dictionary.
+// For a mapped dictionary, this doesn't read the contents of the sections.
+func (t *Trie) verifySizes() error {
+	return t.verifyLayout(false)
+}
+
+// verifyLayout checks the structure of the loaded dictionary without looking
+// up every key. If full is false, only the header and section sizes are
+// checked.
+func (t *Trie) verifyLayout(full bool) error {
+	if t.mod == nil {
+		return errorKind(ErrNotInitialized, nil)
+	}
+	if err := t.err(); err != nil {
+		return err
+	}
+	b, ok := t.image()
+	if !ok {
+		var err error
+		if b, err = t.MarshalBinary(); err != nil {
+			return err
+		}
+	}
+	if !full {
+		_, err := parseLayout(b)
+		return err
+	}
+	return validateLayout(b)
+}
+
+// validateLayout checks the structure of the serialized dictionary b.
+func validateLayout(b []byte) error {
+	l, err := parseLayout(b)
+	if err != nil {
+		return err
+	}
+	return corruptLayoutError(layout.Check(b, l), int64(len(b)))
+}
+
+// parseLayout parses the header and section sizes of the serialized dictionary
+// b, which must not have trailing data.
+func parseLayout(b []byte) (*layout.Trie, error) {
+	l, err := layout.Parse(bytes.NewReader(b), int64(len(b)), binary.LittleEndian, 8)
+	if err != nil {
+		return nil, corruptLayoutError(err, int64(len(b)))
+	}
+	if n := l.IOSize(); n != int64(len(b)) {
+		return nil, corrupt(n, "trailing data after dictionary", nil)
+	}
+	return l, nil
+}
+
+// corruptLayoutError converts errors returned by the layout package into
+// [ErrCorrupt] errors. If the error is io.ErrUnexpectedEOF, the offset is
+// set to size.
+func corruptLayoutError(err error, size int64) error {
+	var lerr *layout.Error
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &lerr):
+		return &Error{Kind: ErrCorrupt, Err: &CorruptError{Offset: lerr.Offset, Field: lerr.Field, Reason: lerr.Reason}}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return corrupt(size, "truncated", err)
+	default:
+		return err
+	}
+}
+
+// verifyKeys ensures every key ID round-trips through a reverse lookup and a
+// lookup. Since the structural checks can't catch every kind of corruption,
+// queries which trap (see module.trap) are also reported as corruption.
+func (t *Trie) verifyKeys() error {
+	for id := range t.size {
+		key, ok, err := t.ReverseLookup(id)
+		if err != nil {
+			return corrupt(-1, "reverse lookup of key "+strconv.FormatUint(uint64(id), 10)+" failed", err)
+		}
+		if !ok {
+			return corrupt(-1, "key "+strconv.FormatUint(uint64(id), 10)+" not found by reverse lookup", nil)
+		}
+		act, ok, err := t.Lookup(key)
+		if err != nil {
+			return corrupt(-1, "lookup of key "+strconv.FormatUint(uint64(id), 10)+" failed", err)
+		}
+		if !ok || act != id {
+			return corrupt(-1, "key "+strconv.FormatUint(uint64(id), 10)+" does not round-trip", nil)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package marisa_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"github.com/pgaskin/go-marisa"
+	"github.com/pgaskin/go-marisa/testdata"
+)
+
+func TestValidate(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		keys []string
+		cfg  marisa.Config
+	}{
+		{"Empty", []string{}, marisa.Config{}},
+		{"Blank", []string{""}, marisa.Config{}},
+		{"Words", testdata.Words, marisa.Config{}},
+		{"Go125", testdata.Go125, marisa.Config{NumTries: 5, TailMode: marisa.BinaryTail, CacheLevel: marisa.HugeCache, NodeOrder: marisa.LabelOrder}},
+		{"Go125Single", testdata.Go125, marisa.Config{NumTries: 1, CacheLevel: marisa.TinyCache}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var trie marisa.Trie
+			if err := trie.Build(slices.Values(c.keys), c.cfg); err != nil {
+				t.Fatalf("error: %v", err)
+			}
+			buf, err := trie.MarshalBinary()
+			if err != nil {
+				t.Fatalf("error: %v", err)
+			}
+			if err := marisa.Validate(bytes.NewReader(buf)); err != nil {
+				t.Errorf("validate: %v", err)
+			}
+			if err := trie.Verify(); err != nil {
+				t.Errorf("verify: %v", err)
+			}
+			if loaded, err := marisa.New(buf); err != nil {
+				t.Errorf("error: %v", err)
+			} else if err := loaded.Verify(); err != nil {
+				t.Errorf("verify in place: %v", err)
+			}
+		})
+	}
+
+	t.Run("Uninitialized", func(t *testing.T) {
+		if err := new(marisa.Trie).Verify(); err == nil {
+			t.Errorf("expected error")
+		}
+	})
+
+	expected := mustWordsTrieData()
+
+	assertCorrupt := func(t *testing.T, name string, b []byte) {
+		t.Helper()
+		err := marisa.Validate(bytes.NewReader(b))
+		if !errors.Is(err, marisa.ErrCorrupt) {
+			t.Errorf("%s: expected corrupt error, got %v", name, err)
+			return
+		}
+		var merr *marisa.Error
+		if !errors.As(err, &merr) || merr.Kind != marisa.ErrCorrupt {
+			t.Errorf("%s: expected *Error with kind ErrCorrupt, got %T", name, err)
+			return
+		}
+		var cerr *marisa.CorruptError
+		if !errors.As(err, &cerr) {
+			t.Errorf("%s: expected *CorruptError, got %T", name, err)
+			return
+		}
+		t.Logf("%s: %v", name, err)
+	}
+
+	t.Run("Corrupt", func(t *testing.T) {
+		assertCorrupt(t, "nil", nil)
+		assertCorrupt(t, "magic", slices.Concat([]byte("We hate Marisa.\x00"), expected[16:]))
+		assertCorrupt(t, "truncated", expected[:len(expected)-1])
+		assertCorrupt(t, "trailing", append(slices.Clone(expected), 0))
+		assertCorrupt(t, "junk", filled(byte(0xFF), 4096))
+
+		b := slices.Clone(expected)
+		b[16+8] ^= 0x01 // first louds unit
+		assertCorrupt(t, "louds", b)
+
+		b = slices.Clone(expected)
+		b[len(b)-1024] ^= 0x10
+		assertCorrupt(t, "cache", b)
+
+		if err := marisa.Validate(bytes.NewReader(expected[:500])); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("expected truncated dictionary error to match io.ErrUnexpectedEOF, got %v", err)
+		}
+	})
+
+	t.Run("Random", func(t *testing.T) {
+		var trie marisa.Trie
+		if err := trie.Build(slices.Values(testdata.Go125[:500]), marisa.Config{}); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		small, err := trie.MarshalBinary()
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		rng := rand.New(rand.NewPCG(1, 2))
+		var detected int
+		for range 2000 {
+			b := slices.Clone(small)
+			for range 1 + rng.IntN(4) {
+				b[rng.IntN(len(b))] ^= byte(1 + rng.IntN(255))
+			}
+			if err := marisa.Validate(bytes.NewReader(b)); err != nil {
+				if !errors.Is(err, marisa.ErrCorrupt) {
+					t.Fatalf("expected corrupt error, got %v", err)
+				}
+				detected++
+			} else if !bytes.Equal(b, small) {
+				// not all corruption is detectable (e.g., changing labels
+				// in the tail), but the result must still be usable
+				trie, err := marisa.New(b)
+				if err != nil {
+					t.Fatalf("validated dictionary failed to load: %v", err)
+				}
+				if _, err := trie.Dump(-1); err != nil {
+					t.Fatalf("validated dictionary failed to dump: %v", err)
+				}
+			}
+		}
+		t.Logf("detected %d/2000", detected)
+	})
+}